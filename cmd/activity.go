@@ -0,0 +1,80 @@
+// chris 2026-10-18 Output activity tracking.
+
+package cmd
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Activity tracks when a command last produced output.
+type Activity struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewActivity returns a new Activity, considering the last output to
+// have been produced now.
+func NewActivity() *Activity {
+	return &Activity{last: time.Now()}
+}
+
+// Reset considers the last output to have been produced now, as when
+// the command starts.
+func (a *Activity) Reset() {
+	a.touch()
+}
+
+// Last returns the time the command last produced output.
+func (a *Activity) Last() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.last
+}
+
+func (a *Activity) touch() {
+	a.mu.Lock()
+	a.last = time.Now()
+	a.mu.Unlock()
+}
+
+// activityWriter notes the time of every write to the underlying
+// writer.
+type activityWriter struct {
+	a *Activity
+	w io.Writer
+}
+
+func (aw activityWriter) Write(p []byte) (int, error) {
+	aw.a.touch()
+	return aw.w.Write(p)
+}
+
+// Writer returns a writer that writes to w, noting the time of every
+// write as output activity.
+func (a *Activity) Writer(w io.Writer) io.Writer {
+	return activityWriter{a, w}
+}
+
+// Idle returns a channel that is closed once the command has produced
+// no output for the given duration.  Watching for idleness stops once
+// stop is closed.
+func (a *Activity) Idle(d time.Duration, stop <-chan struct{}) <-chan struct{} {
+	idle := make(chan struct{})
+	go func() {
+		for {
+			left := d - time.Since(a.Last())
+			if left <= 0 {
+				close(idle)
+				return
+			}
+			select {
+			case <-time.After(left):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return idle
+}
@@ -3,10 +3,13 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"path/filepath"
 )
@@ -26,45 +29,103 @@ type State struct {
 	Me Args
 	// The command it's meant to invoke.
 	Cmd Args
+
+	// Names of the arguments in Me.Args, for error messages.
+	names []string
 }
 
+// Flags is the set of options that a prun command-line implementation
+// accepts preceding its other arguments.  Define any before calling
+// Parse.  Parse itself defines the options common to all prun
+// command-line implementations.
+var Flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+// grace is the grace period given to new Procs, settable with the
+// common -grace option.
+var grace = DefaultGrace
+
 // BadArgs logs the message exits the process with exit status 2.
 func BadArgs(message string) {
 	log.Printf(message)
 	os.Exit(2)
 }
 
-// usage calls BadArgs with a standard usage message, including any
-// additional arguments the prun utility might take preceding the
-// command.
-func usage(name string, args []string) {
-	m := fmt.Sprintf("usage: %s", name)
+// usage displays a standard usage message, including any additional
+// arguments the prun utility might take preceding the command and
+// whether the command is optional, and describes any options.
+func usage(name string, args []string, optional bool) {
+	m := fmt.Sprintf("usage: %s [option ...]", name)
 	if len(args) > 0 {
 		m += " " + strings.Join(args, " ")
 	}
-	m += " command [argument ...]\n"
-	BadArgs(m)
+	if optional {
+		m += " [command [argument ...]]\n"
+	} else {
+		m += " command [argument ...]\n"
+	}
+	log.Print(m)
+	Flags.PrintDefaults()
 }
 
 // Parse constructs a State given any additional arguments the utility
-// might take preceding the command.  If the command-line invocation is
-// incorrect, Parse displays a standard usage message and exits with
-// exit status 2.  The name of the currently-running utility is
-// extracted from the first element of os.Args.
+// might take preceding the command.  Any options defined in Flags are
+// parsed first, up to the first non-option argument or "--".  If the
+// command-line invocation is incorrect, Parse displays a standard
+// usage message and exits with exit status 2.  If help is requested
+// with -h or -help, it displays the same message and exits with exit
+// status 0.  The name of the currently-running utility is extracted
+// from the first element of os.Args.
 func Parse(args ...string) State {
 	name := filepath.Base(os.Args[0])
-	if len(os.Args) < 2+len(args) {
-		usage(name, args)
+	return parse(name, name, os.Args[1:], args, false)
+}
+
+// parse implements Parse for the utility with the given name, parsing
+// the command-line arguments argv.  Any usage message displays prog as
+// the name of the program.  If optional is set, the command may be
+// omitted, in which case the State's Cmd is empty.
+func parse(name, prog string, argv, args []string, optional bool) State {
+	Flags.Init(prog, flag.ContinueOnError)
+	Flags.SetOutput(os.Stderr)
+	Flags.Usage = func() {} // Displayed below instead.
+	// Flags keeps its options across calls, so -grace is defined
+	// only once, and reset to its default otherwise.
+	grace = DefaultGrace
+	if Flags.Lookup("grace") == nil {
+		Flags.DurationVar(&grace, "grace", DefaultGrace, "how long after relaying a signal to the command to kill it")
+	}
+	if err := Flags.Parse(argv); err != nil {
+		usage(prog, args, optional)
+		if err == flag.ErrHelp {
+			os.Exit(0)
+		}
+		// Flags has already displayed the error itself.
+		os.Exit(2)
+	}
+	if grace < 0 {
+		BadArgs("grace must be non-negative")
+	}
+	rest := Flags.Args()
+	if len(rest) == len(args) && optional {
+		return State{
+			Me:    Args{Name: name, Args: rest},
+			names: args,
+		}
+	}
+	if len(rest) < 1+len(args) {
+		usage(prog, args, optional)
+		os.Exit(2)
 	}
 	return State{
 		Me: Args{
 			Name: name,
-			Args: os.Args[1 : 1+len(args)],
+			Args: rest[:len(args)],
 		},
 		Cmd: Args{
-			Name: os.Args[1+len(args)],
-			Args: os.Args[1+len(args)+1:],
+			Name: rest[len(args)],
+			Args: rest[len(args)+1:],
 		},
+		names: args,
 	}
 }
 
@@ -72,3 +133,34 @@ func Parse(args ...string) State {
 func ArgError(err error) {
 	BadArgs(err.Error() + "\n")
 }
+
+// Duration parses the i'th argument preceding the command as a
+// non-negative time.Duration.  If it is invalid, Duration calls
+// BadArgs.
+func (s State) Duration(i int) time.Duration {
+	d, err := time.ParseDuration(s.Me.Args[i])
+	if err != nil {
+		ArgError(err)
+	}
+	if d < 0 {
+		BadArgs(s.names[i] + " must be non-negative")
+	}
+	return d
+}
+
+// Uint parses the i'th argument preceding the command as an unsigned
+// integer no less than min.  It accepts the same bases as
+// strconv.ParseUint with base 0.  If it is invalid, Uint calls BadArgs.
+func (s State) Uint(i int, min uint64) uint64 {
+	n, err := strconv.ParseUint(s.Me.Args[i], 0, 64)
+	if err != nil {
+		ArgError(err)
+	}
+	if n < min {
+		if min == 1 {
+			BadArgs(s.names[i] + " must be positive")
+		}
+		BadArgs(fmt.Sprintf("%s must be at least %d", s.names[i], min))
+	}
+	return n
+}
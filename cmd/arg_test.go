@@ -0,0 +1,89 @@
+// chris 2026-10-18
+
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"os/exec"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		argv     []string
+		args     []string
+		optional bool
+		me, cmd  Args
+		grace    time.Duration
+	}{
+		// Options precede the arguments, which precede the command.
+		{
+			[]string{"-grace", "2s", "1h", "sh", "-c", "exit 3"}, []string{"period"}, false,
+			Args{"prunx", []string{"1h"}}, Args{"sh", []string{"-c", "exit 3"}}, 2 * time.Second,
+		},
+		// Without options, as before there were any.
+		{
+			[]string{"1h", "true"}, []string{"period"}, false,
+			Args{"prunx", []string{"1h"}}, Args{"true", []string{}}, DefaultGrace,
+		},
+		// The command's own options are left alone.
+		{
+			[]string{"ls", "-grace", "3s"}, nil, false,
+			Args{"prunx", []string{}}, Args{"ls", []string{"-grace", "3s"}}, DefaultGrace,
+		},
+		// "--" ends the options.
+		{
+			[]string{"-grace", "1s", "--", "-x", "a"}, nil, false,
+			Args{"prunx", []string{}}, Args{"-x", []string{"a"}}, time.Second,
+		},
+		// An optional command may be omitted.
+		{
+			[]string{":7070"}, []string{"address"}, true,
+			Args{"prunx", []string{":7070"}}, Args{}, DefaultGrace,
+		},
+	} {
+		s := parse("prunx", "prunx", c.argv, c.args, c.optional)
+		if !reflect.DeepEqual(s.Me, c.me) || !reflect.DeepEqual(s.Cmd, c.cmd) || grace != c.grace {
+			t.Errorf("%q: got %+v, %+v, grace %s, not %+v, %+v, grace %s\n", c.argv, s.Me, s.Cmd, grace, c.me, c.cmd, c.grace)
+		}
+	}
+}
+
+// TestParseExit runs the test binary again to parse the arguments in
+// the PRUN_TEST_PARSE environment variable, since parse exits when
+// they're bad or help is requested.
+func TestParseExit(t *testing.T) {
+	if argv := os.Getenv("PRUN_TEST_PARSE"); argv != "" {
+		parse("prunx", "prunx", strings.Fields(argv), []string{"period"}, false)
+		os.Exit(3)
+	}
+	for _, c := range []struct {
+		argv string
+		code int
+	}{
+		{"-h", 0},
+		{"-help 1h true", 0},
+		{"1h", 2},
+		{"-bogus 1h true", 2},
+		{"-grace -1s 1h true", 2},
+		{"1h true", 3},
+	} {
+		p := exec.Command(os.Args[0], "-test.run=^TestParseExit$")
+		p.Env = append(os.Environ(), "PRUN_TEST_PARSE="+c.argv)
+		code := 0
+		if err := p.Run(); err != nil {
+			ee, ok := err.(*exec.ExitError)
+			if !ok {
+				t.Fatalf("%q: %v\n", c.argv, err)
+			}
+			code = ee.ExitCode()
+		}
+		if code != c.code {
+			t.Errorf("%q: exit status %d, not %d\n", c.argv, code, c.code)
+		}
+	}
+}
@@ -2,4 +2,63 @@
 
 // Package cmd provides common code for command-line prun
 // implementations.
+//
+// Options
+//
+// All prun command-line implementations accept options preceding their
+// other arguments, parsed with the flag package.  Options end at the
+// first non-option argument or at "--", so the traditional positional
+// forms, such as "prunfor 10m command", remain valid.  All of them
+// accept the following option.
+//
+//	-grace duration
+//	    How long after relaying a signal to the command to kill
+//	    it (by default, 5s).  If zero, the command is never
+//	    killed.
+//
+// Help is displayed with -h.
+//
+// State Directory
+//
+// prunex, prunevery, and prunfail keep their lock, stat, and log files
+// in a state directory, which they accept the following option to
+// specify.
+//
+//	-statedir dir
+//	    The state directory.
+//
+// Otherwise, the state directory is given by the PRUN_STATE_DIR
+// environment variable, or is prun under XDG_STATE_HOME.  Failing
+// those, it is /var/lib/prun for the superuser and
+// ~/.local/state/prun for other users, or, if there is no home
+// directory, prun-UID in the default temporary directory, where UID is
+// the user ID.
+//
+// The state directory is created, if necessary, accessible only by its
+// owner.  If it is instead shared with other users, a subdirectory
+// named by the user ID is used, so that users can't interfere with
+// each other's files.  A shared directory must then be owned by the
+// superuser or have its sticky bit set, as /tmp does.
+//
+// Keys
+//
+// The lock, stat, and log files are named by a key, by default derived
+// from the command and all of its arguments with MakeKey.  So different
+// commands can't share them, and changing any argument starts afresh.
+// Stat and log files named by keys derived under an older version of
+// MakeKey are migrated, as described for Proc.KeyPath.
+//
+// prunex, prunevery, and prunfail accept the following options to
+// choose the key instead.  At most one of them may be given.
+//
+//	-key key
+//	    Use the given key, made up of word characters, dots, and
+//	    hyphens, such as "nightly-backup".  Commands run with the
+//	    same key share their files.
+//	-keyargs indices
+//	    Derive the key from the command and only the arguments at
+//	    the given comma-separated 1-based indices, such as "1,3".
+//	-keycmd
+//	    Derive the key from the command alone, ignoring its
+//	    arguments.
 package cmd
@@ -0,0 +1,120 @@
+// chris 2026-10-18 Typed option values.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"path/filepath"
+)
+
+// pathValue is a flag.Value for a file system path, made absolute.
+type pathValue string
+
+func (p *pathValue) Set(s string) error {
+	if s == "" {
+		*p = ""
+		return nil
+	}
+	abs, err := filepath.Abs(s)
+	if err != nil {
+		return err
+	}
+	*p = pathValue(abs)
+	return nil
+}
+
+func (p *pathValue) String() string { return string(*p) }
+
+// PathVar defines a path option in Flags with the given name, default
+// value, and usage string.  The option value is made absolute, so that
+// it is unaffected by the command changing directories.  An empty value
+// is left empty.
+func PathVar(p *string, name, value, usage string) {
+	*p = value
+	Flags.Var((*pathValue)(p), name, usage)
+}
+
+// signalValue is a flag.Value for a signal, parsed with ParseSignal.
+type signalValue struct {
+	sig *os.Signal
+}
+
+func (v signalValue) Set(s string) error {
+	sig, err := ParseSignal(s)
+	if err != nil {
+		return err
+	}
+	*v.sig = sig
+	return nil
+}
+
+func (v signalValue) String() string {
+	if v.sig == nil || *v.sig == nil {
+		return ""
+	}
+	sig, ok := (*v.sig).(syscall.Signal)
+	if !ok {
+		return (*v.sig).String()
+	}
+	for name, named := range signalNames {
+		if named == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// SignalVar defines a signal option in Flags with the given name,
+// default value, and usage string.  The option value may be given by
+// name or number, as accepted by ParseSignal.  As with the flag
+// package, a back-quoted word in the usage string names the value.
+func SignalVar(p *os.Signal, name string, value syscall.Signal, usage string) {
+	*p = value
+	Flags.Var(signalValue{p}, name, usage)
+}
+
+// keyValue is a flag.Value for a key, checked with CheckKey.
+type keyValue string
+
+func (k *keyValue) Set(s string) error {
+	if err := CheckKey(s); err != nil {
+		return err
+	}
+	*k = keyValue(s)
+	return nil
+}
+
+func (k *keyValue) String() string { return string(*k) }
+
+// indicesValue is a flag.Value for a comma-separated list of positive
+// indices, such as "1,3".
+type indicesValue []int
+
+func (v *indicesValue) Set(s string) error {
+	var indices []int
+	for _, f := range strings.Split(s, ",") {
+		i, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return err
+		}
+		if i < 1 {
+			return errors.New("index must be positive: " + f)
+		}
+		indices = append(indices, i)
+	}
+	*v = indices
+	return nil
+}
+
+func (v *indicesValue) String() string {
+	f := make([]string, len(*v))
+	for i, index := range *v {
+		f[i] = strconv.Itoa(index)
+	}
+	return strings.Join(f, ",")
+}
@@ -0,0 +1,24 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package cmd
+
+import (
+	"os"
+
+	"os/exec"
+)
+
+// setGroup does nothing: process groups are not supported.
+func setGroup(c *exec.Cmd) {}
+
+// signalGroup sends the signal to just the process with the given ID,
+// since process groups are not supported.
+func signalGroup(pgid int, sig os.Signal) error {
+	p, err := os.FindProcess(pgid)
+	if err != nil {
+		return err
+	}
+	return p.Signal(sig)
+}
@@ -0,0 +1,32 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"os/exec"
+)
+
+// setGroup arranges for the command to be started in a new process
+// group whose ID is the command's process ID.
+func setGroup(c *exec.Cmd) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+}
+
+// signalGroup sends the signal to every process in the process group
+// with the given ID.
+func signalGroup(pgid int, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return errors.New("unsupported signal")
+	}
+	return syscall.Kill(-pgid, s)
+}
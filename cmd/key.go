@@ -3,19 +3,31 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
 
 	"crypto/md5"
+	"crypto/sha256"
 )
 
-// MaxKeyLength is the maximum key length used by MakeKey, over which it
-// will use a hash at its end to stay within the length limit, but still
-// uniquely and deterministically identify the given command and its
-// arguments.
+// MaxKeyLength is the maximum key length used by MakeKey and allowed
+// by CheckKey.
 const MaxKeyLength = 128
 
+// KeyVersion is the version of the scheme by which MakeKey derives
+// keys.  It is part of every key that MakeKey returns, so that keys
+// derived by different schemes never coincide.
+//
+// Version 1 keys, as derived by LegacyKey, consisted of the readable
+// part alone, so that different invocations could share a key.
+const KeyVersion = 2
+
+// keyHashLength is the number of hexadecimal digits of the hash in a
+// key.
+const keyHashLength = 16
+
 var nonwordre = regexp.MustCompile(`[^\w]+`)
 var undscre = regexp.MustCompile(`_{2,}`)
 
@@ -25,28 +37,149 @@ func subnonwords(x string) string {
 	return x
 }
 
-// MakeKey produces a "key" for a given command and its arguments.
-//
-// The intent of the key is to produce a deterministic and reasonably
-// human-readable string that identifies the command being run.  MakeKey
-// consolidates all non-word characters between the command and its
-// arguments and replaces them with underscores.
-//
-// If the length of the key exceeds MaxKeyLength, then it will be
-// truncated and the suffix of the key will be a hash of the full key so
-// as to stay within the length limit, but still uniquely and
-// deterministically identify the given command and its arguments.
-func MakeKey(command string, args []string) string {
+// readable returns the human-readable part of the key for the command
+// and its arguments, with all non-word characters consolidated and
+// replaced by underscores.
+func readable(command string, args []string) string {
 	key := command
 	a := subnonwords(strings.Join(args, "_"))
 	if len(a) > 0 && a != "_" {
 		key += "_" + a
 	}
 	key = subnonwords(key)
-	key = strings.Trim(key, "_")
+	return strings.Trim(key, "_")
+}
+
+// argvHash returns a hash of the command and its arguments that
+// respects the boundaries between them: each is written as its length
+// followed by its bytes.
+func argvHash(command string, args []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "prun key %d\x00", KeyVersion)
+	for _, arg := range append([]string{command}, args...) {
+		fmt.Fprintf(h, "%d:%s,", len(arg), arg)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:keyHashLength]
+}
+
+// MakeKey produces a "key" for a given command and its arguments.
+//
+// The intent of the key is to produce a deterministic and reasonably
+// human-readable string that identifies the command being run.  The key
+// begins with the command and its arguments, with all non-word
+// characters between them consolidated and replaced by underscores,
+// and truncated if necessary to stay within MaxKeyLength.  It ends with
+// the KeyVersion and a hash of the exact command and arguments, so that
+// invocations differing only in punctuation or in how the arguments are
+// split, such as "grep -Rw blah ." and "grep 'Rw blah'", have different
+// keys.  For example,
+//
+//	grep_Rw_blah.v2.e705c445a62cee85
+//
+// If the command and arguments have no word characters, the key begins
+// with the KeyVersion instead, as in "v2.e705c445a62cee85".
+func MakeKey(command string, args []string) string {
+	suffix := fmt.Sprintf(".v%d.%s", KeyVersion, argvHash(command, args))
+	key := readable(command, args)
+	if key == "" {
+		return suffix[1:]
+	}
+	if len(key) > MaxKeyLength-len(suffix) {
+		key = key[:MaxKeyLength-len(suffix)]
+	}
+	return key + suffix
+}
+
+// LegacyKey produces the version 1 key for a given command and its
+// arguments, as MakeKey did before KeyVersion 2.  It is the readable
+// part of the key alone, truncated if necessary with the suffix
+// replaced by a hash of the full readable part.
+func LegacyKey(command string, args []string) string {
+	key := readable(command, args)
 	if len(key) > MaxKeyLength {
 		hash := fmt.Sprintf("%x", md5.Sum([]byte(key)))
 		key = fmt.Sprintf("%s%s", key[:MaxKeyLength-len(hash)], hash)
 	}
 	return key
 }
+
+var keyre = regexp.MustCompile(`^[\w-][\w.-]*$`)
+
+// CheckKey returns an error unless key is a valid explicitly-chosen key:
+// a non-empty string of at most MaxKeyLength word characters, dots, and
+// hyphens, not beginning with a dot, so that it's safe to use in file
+// names.
+func CheckKey(key string) error {
+	if len(key) > MaxKeyLength {
+		return fmt.Errorf("key longer than %d: %s", MaxKeyLength, key)
+	}
+	if !keyre.MatchString(key) {
+		return errors.New("invalid key: " + key)
+	}
+	return nil
+}
+
+// SelectArgs returns the arguments at the given 1-based indices, in the
+// given order.  It returns an error if any index is out of range.
+func SelectArgs(args []string, indices []int) ([]string, error) {
+	selected := make([]string, len(indices))
+	for i, index := range indices {
+		if index < 1 || index > len(args) {
+			return nil, fmt.Errorf("no argument %d", index)
+		}
+		selected[i] = args[index-1]
+	}
+	return selected, nil
+}
+
+// The key options, settable with -key, -keyargs, and -keycmd.
+var (
+	key     string
+	keyargs []int
+	keycmd  bool
+)
+
+// KeyVar defines the options choosing the key in Flags.  Modes that
+// keep lock, stat, or log files named by a key call it to define their
+// options, and then call State.Key.
+func KeyVar() {
+	key, keyargs, keycmd = "", nil, false
+	Flags.Var((*keyValue)(&key), "key", "use `key` to name lock, stat, and log files")
+	Flags.Var((*indicesValue)(&keyargs), "keyargs", "derive the key from only the comma-separated argument `indices`")
+	Flags.BoolVar(&keycmd, "keycmd", false, "derive the key from the command alone")
+}
+
+// ExplicitKey returns the key given by -key, if any.
+func ExplicitKey() string {
+	return key
+}
+
+// Key returns the key naming the command's lock, stat, and log files.
+// It is the key given by -key, if any, or otherwise derived by MakeKey
+// from the command and either the arguments selected by -keyargs, no
+// arguments if -keycmd is given, or all of them.  If the options are
+// invalid, Key calls BadArgs.
+func (s State) Key() string {
+	n := 0
+	for _, set := range []bool{key != "", keyargs != nil, keycmd} {
+		if set {
+			n++
+		}
+	}
+	if n > 1 {
+		BadArgs("at most one of key, keyargs, and keycmd may be given")
+	}
+	switch {
+	case key != "":
+		return key
+	case keycmd:
+		return MakeKey(s.Cmd.Name, nil)
+	case keyargs != nil:
+		args, err := SelectArgs(s.Cmd.Args, keyargs)
+		if err != nil {
+			ArgError(err)
+		}
+		return MakeKey(s.Cmd.Name, args)
+	}
+	return MakeKey(s.Cmd.Name, s.Cmd.Args)
+}
@@ -3,45 +3,149 @@
 package cmd
 
 import (
+	"os"
 	"strings"
 	"testing"
+
+	"io/ioutil"
+	"path/filepath"
 )
 
-func testMakeKeyExpect(t *testing.T, command string, args []string, expect string) {
-	key := MakeKey(command, args)
+func testLegacyKeyExpect(t *testing.T, command string, args []string, expect string) {
+	key := LegacyKey(command, args)
 	t.Logf("key %q\n", key)
 	if key != expect {
-		t.Errorf("MakeKey(%q, %q) != %q (got %q)\n", command, args, expect, key)
+		t.Errorf("LegacyKey(%q, %q) != %q (got %q)\n", command, args, expect, key)
 	}
 }
 
-func testMakeKeyLong(t *testing.T, command string, args []string) {
-	key := MakeKey(command, args)
+func testLegacyKeyLong(t *testing.T, command string, args []string) {
+	key := LegacyKey(command, args)
 	t.Logf("long key %q\n", key)
-	if len(key) > maxkeylen {
-		t.Errorf("%q longer than %d\n", key, maxkeylen)
+	if len(key) > MaxKeyLength {
+		t.Errorf("%q longer than %d\n", key, MaxKeyLength)
 	}
 }
 
-func TestMakeKey(t *testing.T) {
-	testMakeKeyExpect(t, "ls", []string{"-l"}, "ls_l")
-	testMakeKeyExpect(t, "ls", []string{""}, "ls")
-	testMakeKeyExpect(t, "ls", []string{"", ""}, "ls")
-	testMakeKeyExpect(t, "", []string{}, "")
-	testMakeKeyExpect(t, "", []string{}, "")
-	testMakeKeyExpect(t, "", []string{"x"}, "_x")
-	testMakeKeyExpect(t, "grep", []string{"-Rw", "blah", "."}, "grep_Rw_blah_")
+func TestLegacyKey(t *testing.T) {
+	testLegacyKeyExpect(t, "ls", []string{"-l"}, "ls_l")
+	testLegacyKeyExpect(t, "ls", []string{""}, "ls")
+	testLegacyKeyExpect(t, "ls", []string{"", ""}, "ls")
+	testLegacyKeyExpect(t, "", []string{}, "")
+	testLegacyKeyExpect(t, "", []string{"x"}, "x")
+	testLegacyKeyExpect(t, "grep", []string{"-Rw", "blah", "."}, "grep_Rw_blah")
 
 	var longcommand string
 
-	longcommand = strings.Repeat("a", maxkeylen-2)
-	testMakeKeyExpect(t, longcommand, []string{}, longcommand)
-	testMakeKeyExpect(t, longcommand, []string{"x"}, longcommand+"_x")
-	longcommand = strings.Repeat("b", maxkeylen-1)
-	testMakeKeyExpect(t, longcommand, []string{}, longcommand)
-	longcommand = strings.Repeat("c", maxkeylen)
-	testMakeKeyExpect(t, longcommand, []string{}, longcommand)
+	longcommand = strings.Repeat("a", MaxKeyLength-2)
+	testLegacyKeyExpect(t, longcommand, []string{}, longcommand)
+	testLegacyKeyExpect(t, longcommand, []string{"x"}, longcommand+"_x")
+	longcommand = strings.Repeat("b", MaxKeyLength-1)
+	testLegacyKeyExpect(t, longcommand, []string{}, longcommand)
+	longcommand = strings.Repeat("c", MaxKeyLength)
+	testLegacyKeyExpect(t, longcommand, []string{}, longcommand)
+
+	testLegacyKeyLong(t, strings.Repeat("d", MaxKeyLength+1), []string{})
+	testLegacyKeyLong(t, strings.Repeat("e", 2*MaxKeyLength), []string{})
+}
+
+func testMakeKeyPrefix(t *testing.T, command string, args []string, prefix string) string {
+	key := MakeKey(command, args)
+	t.Logf("key %q\n", key)
+	if prefix != "" {
+		prefix += "."
+	}
+	if !strings.HasPrefix(key, prefix+"v2.") {
+		t.Errorf("MakeKey(%q, %q) doesn't begin with %q (got %q)\n", command, args, prefix, key)
+	}
+	if len(key) > MaxKeyLength {
+		t.Errorf("%q longer than %d\n", key, MaxKeyLength)
+	}
+	if err := CheckKey(key); err != nil {
+		t.Errorf("MakeKey(%q, %q) is invalid: %v\n", command, args, err)
+	}
+	if again := MakeKey(command, args); again != key {
+		t.Errorf("MakeKey(%q, %q) not deterministic (got %q then %q)\n", command, args, key, again)
+	}
+	return key
+}
 
-	testMakeKeyLong(t, strings.Repeat("d", maxkeylen+1), []string{})
-	testMakeKeyLong(t, strings.Repeat("e", 2*maxkeylen), []string{})
+func TestMakeKey(t *testing.T) {
+	testMakeKeyPrefix(t, "ls", []string{"-l"}, "ls_l")
+	testMakeKeyPrefix(t, "", []string{}, "")
+	testMakeKeyPrefix(t, strings.Repeat("a", 2*MaxKeyLength), []string{}, strings.Repeat("a", MaxKeyLength-len(".v2.")-keyHashLength))
+
+	// These all used to collide.
+	keys := map[string]bool{}
+	for _, argv := range [][]string{
+		{"grep", "-Rw", "blah", "."},
+		{"grep", "Rw", "blah"},
+		{"grep", "Rw blah"},
+		{"grep", "Rw", "blah", ""},
+		{"grep", "Rw", "", "blah"},
+	} {
+		key := testMakeKeyPrefix(t, argv[0], argv[1:], "grep_Rw_blah")
+		if keys[key] {
+			t.Errorf("MakeKey(%q, %q) collides\n", argv[0], argv[1:])
+		}
+		keys[key] = true
+	}
+}
+
+func TestKeyPathMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old, ok := os.LookupEnv(StateDirEnv)
+	os.Setenv(StateDirEnv, dir)
+	defer func() {
+		if ok {
+			os.Setenv(StateDirEnv, old)
+		} else {
+			os.Unsetenv(StateDirEnv)
+		}
+	}()
+
+	// Only a file named by the key derived from the whole command
+	// line is migrated.
+	p := NewProc("ls", []string{"-l"})
+	for _, key := range []string{"nightly", MakeKey("ls", nil), MakeKey("ls", []string{"-l"})} {
+		legacy := filepath.Join(dir, "test_ls_l")
+		if err := ioutil.WriteFile(legacy, []byte("state"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		path, err := p.KeyPath("test_", key, "")
+		if err != nil {
+			t.Fatalf("KeyPath(%q) errored: %v\n", key, err)
+		}
+		data, _ := ioutil.ReadFile(path)
+		if migrated := string(data) == "state"; migrated != (key == MakeKey("ls", []string{"-l"})) {
+			t.Errorf("KeyPath(%q) migrated %v\n", key, migrated)
+		}
+	}
+}
+
+func TestCheckKey(t *testing.T) {
+	for _, key := range []string{"nightly-backup", "my.job", "x", "_x"} {
+		if err := CheckKey(key); err != nil {
+			t.Errorf("CheckKey(%q) errored: %v\n", key, err)
+		}
+	}
+	for _, key := range []string{"", ".x", "..", "a/b", "a b", strings.Repeat("a", MaxKeyLength+1)} {
+		if err := CheckKey(key); err == nil {
+			t.Errorf("CheckKey(%q) didn't error\n", key)
+		}
+	}
+}
+
+func TestSelectArgs(t *testing.T) {
+	args, err := SelectArgs([]string{"a", "b", "c"}, []int{3, 1})
+	if err != nil || strings.Join(args, " ") != "c a" {
+		t.Errorf("SelectArgs returned %q, %v\n", args, err)
+	}
+	if _, err := SelectArgs([]string{"a"}, []int{2}); err == nil {
+		t.Errorf("SelectArgs didn't error on out of range index\n")
+	}
 }
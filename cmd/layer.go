@@ -0,0 +1,50 @@
+// chris 2026-10-18 Layers: composable prun behaviors.
+
+package cmd
+
+import "os"
+
+// Runner runs a Proc's command to completion.  It returns a *ProcError
+// if something went wrong or the command exited unsuccessfully.
+type Runner func(p *Proc) *ProcError
+
+// Layer wraps a Runner with additional behavior, such as running the
+// command exclusively or for a limited amount of time, so that several
+// behaviors can be stacked in a single process.
+//
+// A Layer may adjust the Proc before calling the Runner it wraps, for
+// instance by wrapping its standard output and error.  If the Layer
+// fails on its own account, it returns a *ProcError with its own exit
+// code, without running what it wraps.  Otherwise, it generally returns
+// whatever the Runner it wraps returns.
+type Layer func(next Runner) Runner
+
+// Exec is the innermost Runner: it starts the command and waits on it.
+func Exec(p *Proc) *ProcError {
+	if perr := p.StartError(); perr != nil {
+		return perr
+	}
+	return p.WaitError()
+}
+
+// Stack returns a Runner that runs the command through the layers
+// around Exec, the first layer outermost.
+func Stack(layers ...Layer) Runner {
+	run := Runner(Exec)
+	for i := len(layers) - 1; i >= 0; i-- {
+		run = layers[i](run)
+	}
+	return run
+}
+
+// Run runs the command given by the State through the layers, passing
+// through its standard output and error.  If anything goes wrong, it
+// exits the parent process with a useful message and exit status.
+func (s State) Run(layers ...Layer) {
+	proc := NewProc(s.Cmd.Name, s.Cmd.Args)
+	proc.Cmd.Stdout = os.Stdout
+	proc.Cmd.Stderr = os.Stderr
+	if perr := Stack(layers...)(proc); perr != nil {
+		perr.Exit()
+	}
+}
@@ -0,0 +1,148 @@
+// chris 2026-10-18 Modes: prun command-line implementations.
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"path/filepath"
+)
+
+// Code describes an exit code.
+type Code struct {
+	Code int
+	Msg  string
+}
+
+// CommonCodes are the exit codes common to all modes.
+var CommonCodes = []Code{
+	{1, "An unidentified error occurred when trying to run or wait on the command."},
+	{2, "Invalid arguments."},
+	{127, "The command could not be found."},
+	{255, "The command exited unsuccessfully, but the underlying operating system does not support examining the exit status."},
+}
+
+// Mode describes a prun command-line implementation, so that it can be
+// run either as its own program or as a subcommand of a program that
+// multiplexes several of them.
+type Mode struct {
+	// The name of the mode, such as "prunex".  It is also used to
+	// name any files the mode keeps, regardless of how the mode is
+	// invoked.
+	Name string
+	// A brief, one-line description.
+	Synopsis string
+	// Any additional arguments the mode takes preceding the
+	// command, as for Parse.
+	Args []string
+	// Whether the command may be omitted, in which case the Cmd of
+	// the State passed to Main is empty.
+	Optional bool
+	// The exit codes specific to the mode.
+	Codes []Code
+
+	// Flags, if non-nil, defines the mode's options in Flags.
+	Flags func()
+	// Main runs the mode given its parsed State.  If it returns,
+	// the process exits successfully.
+	Main func(State)
+}
+
+// Sub returns the name of the mode as a subcommand, that is, without
+// the "prun" prefix.
+func (m *Mode) Sub() string {
+	return strings.TrimPrefix(m.Name, "prun")
+}
+
+// run runs the mode, displaying prog in any usage message and parsing
+// the given command-line arguments.
+func (m *Mode) run(prog string, argv []string) {
+	log.SetFlags(0)
+	if m.Flags != nil {
+		m.Flags()
+	}
+	m.Main(parse(m.Name, prog, argv, m.Args, m.Optional))
+}
+
+// Run runs the mode as its own program, parsing os.Args.
+func Run(m *Mode) {
+	m.run(filepath.Base(os.Args[0]), os.Args[1:])
+}
+
+// dispatchUsage displays a usage message for Dispatch.
+func dispatchUsage(prog string) {
+	log.Printf("usage: %s mode [option ...] [argument ...] command [argument ...]\n", prog)
+	log.Printf("       %s help [mode]\n", prog)
+}
+
+// help describes all the modes and their exit codes.
+func help(prog string, modes []*Mode) {
+	dispatchUsage(prog)
+	log.Print("\nModes:\n")
+	for _, m := range modes {
+		log.Printf("  %-10s %s\n", m.Sub(), m.Synopsis)
+		for _, c := range m.Codes {
+			log.Printf("  %10d %s\n", c.Code, c.Msg)
+		}
+	}
+	log.Print("\nAll modes may also exit with:\n")
+	for _, c := range CommonCodes {
+		log.Printf("  %10d %s\n", c.Code, c.Msg)
+	}
+	log.Printf("  %10s %s\n", "128+N", "The command was killed by signal N.")
+	log.Print("\nOtherwise, they exit with the exit code of the command.\n")
+}
+
+// lookup returns the mode with the given name, with or without the
+// "prun" prefix, or nil if there isn't one.
+func lookup(modes []*Mode, name string) *Mode {
+	for _, m := range modes {
+		if name == m.Name || name == m.Sub() {
+			return m
+		}
+	}
+	return nil
+}
+
+// Dispatch runs one of the given modes, so that a single program can
+// implement all of them.  If the program is invoked under the name of a
+// mode, for instance by means of a symbolic link named "prunex", it
+// runs that mode.  Otherwise, the first argument names the mode, with
+// or without the "prun" prefix.
+//
+// "help" lists the modes and their exit codes, and "help mode" displays
+// the usage of the given mode.
+func Dispatch(modes ...*Mode) {
+	log.SetFlags(0)
+	prog := filepath.Base(os.Args[0])
+	if m := lookup(modes, prog); m != nil {
+		m.run(prog, os.Args[1:])
+		return
+	}
+	if len(os.Args) < 2 {
+		dispatchUsage(prog)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "-help" {
+		if len(os.Args) < 3 {
+			help(prog, modes)
+			os.Exit(0)
+		}
+		m := lookup(modes, os.Args[2])
+		if m == nil {
+			BadArgs(fmt.Sprintf("unknown mode: %s\n", os.Args[2]))
+		}
+		m.run(prog+" "+m.Sub(), []string{"-h"})
+		return
+	}
+	m := lookup(modes, name)
+	if m == nil {
+		dispatchUsage(prog)
+		BadArgs(fmt.Sprintf("unknown mode: %s\n", name))
+	}
+	m.run(prog+" "+m.Sub(), os.Args[2:])
+}
@@ -0,0 +1,50 @@
+// chris 2026-10-18 Boot-relative clocks (Linux only).
+
+package prunevery
+
+import (
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"io/ioutil"
+)
+
+// Clock IDs, from linux/time.h.
+const (
+	clockMonotonic = 1
+	clockBoottime  = 7
+)
+
+// bootIDPath is where the kernel keeps a random ID it generates on
+// boot.
+const bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+// clockGettime reads the clock with the given ID.
+func clockGettime(id int) (time.Duration, error) {
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, uintptr(id), uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return time.Duration(ts.Nano()), nil
+}
+
+// ReadClocks reads the clocks, or returns the zero Clocks if they can't
+// be read.
+func ReadClocks() Clocks {
+	id, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return Clocks{}
+	}
+	boot, err := clockGettime(clockBoottime)
+	if err != nil {
+		return Clocks{}
+	}
+	mono, err := clockGettime(clockMonotonic)
+	if err != nil {
+		return Clocks{}
+	}
+	return Clocks{BootID: strings.TrimSpace(string(id)), Boottime: boot, Monotonic: mono}
+}
@@ -0,0 +1,11 @@
+// chris 2026-10-18
+
+//go:build !linux
+
+package prunevery
+
+// ReadClocks returns the zero Clocks: reading them is supported on
+// Linux only.
+func ReadClocks() Clocks {
+	return Clocks{}
+}
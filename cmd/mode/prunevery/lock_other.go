@@ -0,0 +1,26 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package prunevery
+
+import (
+	"os"
+)
+
+// lockFile does nothing: locking is supported on Unix-like systems
+// only.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// tryLockFile reports that the file was locked, although locking is
+// supported on Unix-like systems only.
+func tryLockFile(f *os.File) (bool, error) {
+	return true, nil
+}
+
+// alive reports that the process exists, since it can't be determined.
+func alive(pid int) bool {
+	return true
+}
@@ -0,0 +1,41 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package prunevery
+
+import (
+	"os"
+	"syscall"
+)
+
+// flock applies or removes a lock on the file, retrying if interrupted.
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// lockFile locks the file exclusively, waiting for it if need be.
+func lockFile(f *os.File) error {
+	return flock(f, syscall.LOCK_EX)
+}
+
+// tryLockFile locks the file exclusively without waiting, reporting
+// whether it could.
+func tryLockFile(f *os.File) (bool, error) {
+	err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// alive reports whether the process with the given ID exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,820 @@
+// chris 090615
+
+// Package prunevery implements the prunevery mode, which enforces a
+// minimum period between executions of a command.
+//
+// See chrispennello.com/go/prun/cmd/prunevery for its documentation.
+package prunevery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"io/ioutil"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+const name = "prunevery"
+
+// StatPrefix prefixes the names of stat files, which are followed by the
+// key.
+const StatPrefix = name + "_"
+
+// Mode is the prunevery mode.
+var Mode = &cmd.Mode{
+	Name:     name,
+	Synopsis: "Enforce a minimum period between executions of a command.",
+	Args:     []string{"period"},
+	Codes: []cmd.Code{
+		{Code: 10, Msg: "Minimum period not yet elapsed."},
+		{Code: 11, Msg: "Error opening, creating, examining, or updating the stat file."},
+		{Code: 12, Msg: "A previous run is still in progress."},
+	},
+	Flags: flags,
+	Main:  run,
+}
+
+var state struct {
+	cmd cmd.State
+
+	// Minimum periodic execution interval to enforce.
+	period time.Duration
+	opts   Options
+}
+
+// Options are the options that prunevery applies to the period.
+type Options struct {
+	// Whether to stamp the period only once the command succeeds,
+	// rather than as soon as it's run, so that failed runs don't
+	// count toward it.  Nothing is stamped while the command runs,
+	// so unless SkipRunning is set, too, invocations while it runs
+	// run it again.
+	OnSuccess bool
+	// With OnSuccess, how long to wait after a failed run before
+	// trying again, doubling with each further consecutive failure,
+	// up to the period.  If zero, a failed run may be retried right
+	// away.
+	Retry time.Duration
+	// Whether to refuse to run while a previous run of the command
+	// is still in progress, even if the period has elapsed.
+	SkipRunning bool
+	// A schedule, as for ParseSchedule, to run the command at most
+	// once per window of, besides enforcing the period, if not empty.
+	Schedule string
+	// Whether to bound each window of the schedule to the day on
+	// which it begins, so that a window that's missed isn't made up
+	// on a day that the schedule doesn't match.
+	SameDay bool
+	// Whether to count only time the machine is awake toward the
+	// period, rather than also time it spends suspended.
+	Awake bool
+	// What to do if the stat file was stamped in the future, as when
+	// the clock has been set back: SkewClamp, the default if empty,
+	// or SkewRun.
+	Skew string
+}
+
+// What to do about a stat file stamped in the future.
+const (
+	// Count the period from now, as if it had been stamped now.
+	SkewClamp = "clamp"
+	// Run the command now, as if it had never been stamped.
+	SkewRun = "run"
+)
+
+// Flags defines the options in cmd.Flags.
+func (o *Options) Flags() {
+	cmd.Flags.BoolVar(&o.OnSuccess, "onsuccess", false, "count only successful runs toward the period")
+	cmd.Flags.DurationVar(&o.Retry, "retry", 0, "with -onsuccess, wait `backoff` after a failure, doubling with each")
+	cmd.Flags.BoolVar(&o.SkipRunning, "skiprunning", false, "refuse to run while a previous run is in progress")
+	cmd.Flags.StringVar(&o.Schedule, "schedule", "", "run at most once per window of the cron-style `schedule`")
+	cmd.Flags.BoolVar(&o.SameDay, "sameday", false, "with -schedule, end each window with the day it begins on")
+	cmd.Flags.BoolVar(&o.Awake, "awake", false, "count only time the machine is awake toward the period")
+	cmd.Flags.StringVar(&o.Skew, "skew", SkewClamp, "if stamped in the future, `clamp` the stamp to now or run now")
+}
+
+// Check returns an error if any of the options are invalid.
+func (o *Options) Check() error {
+	if o.Retry < 0 {
+		return errors.New("retry must be non-negative")
+	}
+	if o.Retry > 0 && !o.OnSuccess {
+		return errors.New("retry requires onsuccess")
+	}
+	if o.Schedule != "" {
+		if _, err := ParseSchedule(o.Schedule); err != nil {
+			return err
+		}
+		if o.Awake {
+			return errors.New("awake is incompatible with schedule")
+		}
+	} else if o.SameDay {
+		return errors.New("sameday requires schedule")
+	}
+	switch o.Skew {
+	case "", SkewClamp, SkewRun:
+	default:
+		return errors.New("skew must be clamp or run")
+	}
+	return nil
+}
+
+// flags defines the options.
+func flags() {
+	state.opts.Flags()
+	cmd.StateDirVar()
+	cmd.KeyVar()
+}
+
+// setup initializes the state given the parsed command line.
+func setup(s cmd.State) {
+	state.cmd = s
+
+	state.period = state.cmd.Duration(0)
+	if err := state.opts.Check(); err != nil {
+		cmd.BadArgs(err.Error())
+	}
+}
+
+// Outcomes of a run, as recorded.
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Record is what a stat file records of the command's runs.
+type Record struct {
+	// When the period was last stamped: when the command was last
+	// run, or, with Options.OnSuccess, when it last succeeded.  It's
+	// zero if it never has.
+	Last time.Time
+	// The minimum period enforced then, or zero if it isn't known,
+	// as for stat files written by older versions of prunevery.
+	Period time.Duration
+	// The schedule enforced then, and whether its windows were
+	// bounded to their days, as for Options.Schedule and
+	// Options.SameDay.
+	Schedule string
+	SameDay  bool
+	// When prunevery first recorded the command, or zero if it
+	// isn't known, as for stat files written by older versions.  A
+	// command that has never run may run only in a window that
+	// begins at or after then.
+	Created time.Time
+	// The clock by which the period is measured, ClockBoot or
+	// ClockAwake, and its readings when the period was last stamped,
+	// or empty if they weren't read.
+	Clock  string
+	Clocks Clocks
+
+	// The outcome of the last run, Success or Failure, or empty if
+	// it isn't known.
+	Outcome string
+	// How many runs in a row have failed, and when the last of them
+	// finished.
+	Failures int
+	Failed   time.Time
+	// The backoff before retrying after a failure, as for
+	// Options.Retry.
+	Retry time.Duration
+
+	// When the last run started, which might still be in progress,
+	// and when the last run finished, with its exit status: zero if
+	// it succeeded, or else the exit code with which prunevery would
+	// exit.
+	Start, Finish time.Time
+	Status        int
+	// How many times in a row the command wasn't run, because the
+	// period hadn't elapsed or a previous run was in progress.
+	Skips int
+	// The most recent runs, up to HistoryLength, oldest first.
+	History []Attempt
+}
+
+// HistoryLength is how many of the most recent runs a stat file keeps
+// in its history.
+const HistoryLength = 10
+
+// Attempt is a run of the command, as recorded in the history.
+type Attempt struct {
+	Start   time.Time
+	Elapsed time.Duration
+	Status  int
+}
+
+// Clocks by which the period is measured.
+const (
+	// The time since boot, including time spent suspended.
+	ClockBoot = "boot"
+	// The time since boot, excluding time spent suspended.
+	ClockAwake = "awake"
+)
+
+// Clocks are readings of the clocks that setting the wall clock doesn't
+// affect, which start over on each boot.
+type Clocks struct {
+	// An ID identifying the boot during which they were read.
+	BootID string
+	// CLOCK_BOOTTIME and CLOCK_MONOTONIC, which measure the time
+	// since boot, including and excluding time spent suspended.
+	Boottime, Monotonic time.Duration
+}
+
+// stamp stamps the period at now, given the current clocks.
+func (r *Record) stamp(now time.Time, c Clocks, opts Options) {
+	r.Last = now
+	r.Clocks = c
+	r.Clock = ClockBoot
+	if opts.Awake {
+		r.Clock = ClockAwake
+	}
+	if c.BootID == "" {
+		r.Clock = ""
+	}
+}
+
+// Adjust returns the Record with Last adjusted to the wall clock as of
+// now, given the current clocks.  If they were read during the same
+// boot as when the period was last stamped, Last is as long before now
+// as has since elapsed by r.Clock, so that setting the wall clock, or
+// with ClockAwake, suspending the machine, doesn't count toward the
+// period.  Otherwise, Last is left as it is.
+func (r Record) Adjust(now time.Time, c Clocks) Record {
+	if r.Last.IsZero() || r.Clocks.BootID == "" || r.Clocks.BootID != c.BootID {
+		return r
+	}
+	elapsed := c.Boottime - r.Clocks.Boottime
+	if r.Clock == ClockAwake {
+		elapsed = c.Monotonic - r.Clocks.Monotonic
+	}
+	if elapsed >= 0 {
+		r.Last = now.Add(-elapsed)
+	}
+	return r
+}
+
+// Skewed reports whether the period was stamped, or the last failure
+// recorded, after now, as when the clock has since been set back.
+func (r Record) Skewed(now time.Time) bool {
+	return r.Last.After(now) || r.Failed.After(now)
+}
+
+// Next returns when, as of now, the command is next eligible to run: a
+// time no later than now if it's eligible now, or the zero Time if
+// neither the period nor the schedule is known.
+func (r Record) Next(now time.Time) time.Time {
+	sched := r.schedule()
+	if r.Period == 0 && sched == nil {
+		return time.Time{}
+	}
+	next := r.Last.Add(r.Period)
+	if r.Failures > 0 && r.Retry > 0 {
+		limit := r.Period
+		if sched != nil {
+			if w := sched.Next(r.Failed).Sub(r.Failed); limit == 0 || w < limit {
+				limit = w
+			}
+		}
+		if retry := r.Failed.Add(r.backoff(limit)); retry.After(next) {
+			next = retry
+		}
+	}
+	if sched == nil {
+		return next
+	}
+	// A window must have begun since the command last ran, or, if it
+	// never has, since it was first recorded, in the minute of which
+	// a window may have begun.
+	after := r.Last
+	if after.IsZero() {
+		if r.Created.IsZero() {
+			return next
+		}
+		after = r.Created.Truncate(time.Minute).Add(-time.Nanosecond)
+	}
+	// Bounded windows may close again, so only whether one is open
+	// from now on matters.
+	if r.SameDay && now.After(next) {
+		next = now
+	}
+	return sched.open(after, next, r.SameDay)
+}
+
+// schedule returns the Schedule recorded, or nil if there's none.
+func (r Record) schedule() *Schedule {
+	if r.Schedule == "" {
+		return nil
+	}
+	s, err := ParseSchedule(r.Schedule)
+	if err != nil {
+		return nil
+	}
+	return s
+}
+
+// backoff returns how long to wait after the last failure before
+// retrying: the retry backoff, doubled for each failure after the
+// first, up to the limit: the period, or, with a schedule, until the
+// next window begins, if sooner.
+func (r Record) backoff(limit time.Duration) time.Duration {
+	d := r.Retry
+	for i := 1; i < r.Failures && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
+
+// never is the modification time of a stat file recording a command
+// that has never been stamped.
+var never = time.Unix(0, 0)
+
+// encode returns the contents of a stat file recording the Record: one
+// "name value" line per field known, except Last, which is the file's
+// modification time, and one "history start elapsed status" line per
+// Attempt in the history.
+func (r Record) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "period %s\n", r.Period)
+	if r.Schedule != "" {
+		fmt.Fprintf(&b, "schedule %s\n", r.Schedule)
+	}
+	if r.SameDay {
+		fmt.Fprintf(&b, "sameday true\n")
+	}
+	if !r.Created.IsZero() {
+		fmt.Fprintf(&b, "created %s\n", r.Created.Format(time.RFC3339))
+	}
+	if r.Clock != "" {
+		fmt.Fprintf(&b, "clock %s\n", r.Clock)
+		fmt.Fprintf(&b, "bootid %s\n", r.Clocks.BootID)
+		fmt.Fprintf(&b, "boottime %s\n", r.Clocks.Boottime)
+		fmt.Fprintf(&b, "monotonic %s\n", r.Clocks.Monotonic)
+	}
+	if !r.Start.IsZero() {
+		fmt.Fprintf(&b, "start %s\n", r.Start.Format(time.RFC3339))
+	}
+	if !r.Finish.IsZero() {
+		fmt.Fprintf(&b, "finish %s\n", r.Finish.Format(time.RFC3339))
+		fmt.Fprintf(&b, "status %d\n", r.Status)
+	}
+	if r.Outcome != "" {
+		fmt.Fprintf(&b, "outcome %s\n", r.Outcome)
+	}
+	if r.Failures > 0 {
+		fmt.Fprintf(&b, "failures %d\n", r.Failures)
+		fmt.Fprintf(&b, "failed %s\n", r.Failed.Format(time.RFC3339))
+	}
+	if r.Retry > 0 {
+		fmt.Fprintf(&b, "retry %s\n", r.Retry)
+	}
+	if r.Skips > 0 {
+		fmt.Fprintf(&b, "skips %d\n", r.Skips)
+	}
+	for _, a := range r.History {
+		fmt.Fprintf(&b, "history %s %s %d\n", a.Start.Format(time.RFC3339), a.Elapsed, a.Status)
+	}
+	return b.Bytes()
+}
+
+// ReadRecord reads the Record from the stat file at path.  The time of
+// the last run is the file's modification time, and everything else is
+// recorded in its contents, if at all, as for stat files written by
+// older versions of prunevery.
+func ReadRecord(path string) (Record, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return Record{}, err
+	}
+	var r Record
+	if !fi.ModTime().Equal(never) {
+		r.Last = fi.ModTime()
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Record{}, err
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) == 4 && f[0] == "history" {
+			r.History = append(r.History, parseAttempt(f[1:]))
+			continue
+		}
+		if len(f) > 1 && f[0] == "schedule" {
+			r.Schedule = strings.Join(f[1:], " ")
+			continue
+		}
+		if len(f) != 2 {
+			continue
+		}
+		switch f[0] {
+		case "period":
+			r.Period, _ = time.ParseDuration(f[1])
+		case "sameday":
+			r.SameDay = f[1] == "true"
+		case "created":
+			r.Created, _ = time.Parse(time.RFC3339, f[1])
+		case "start":
+			r.Start, _ = time.Parse(time.RFC3339, f[1])
+		case "finish":
+			r.Finish, _ = time.Parse(time.RFC3339, f[1])
+		case "status":
+			r.Status, _ = strconv.Atoi(f[1])
+		case "skips":
+			r.Skips, _ = strconv.Atoi(f[1])
+		case "clock":
+			r.Clock = f[1]
+		case "bootid":
+			r.Clocks.BootID = f[1]
+		case "boottime":
+			r.Clocks.Boottime, _ = time.ParseDuration(f[1])
+		case "monotonic":
+			r.Clocks.Monotonic, _ = time.ParseDuration(f[1])
+		case "outcome":
+			r.Outcome = f[1]
+		case "failures":
+			r.Failures, _ = strconv.Atoi(f[1])
+		case "failed":
+			r.Failed, _ = time.Parse(time.RFC3339, f[1])
+		case "retry":
+			r.Retry, _ = time.ParseDuration(f[1])
+		}
+	}
+	return r, nil
+}
+
+// parseAttempt parses the start, elapsed time, and status of an
+// Attempt in the history.
+func parseAttempt(f []string) Attempt {
+	var a Attempt
+	a.Start, _ = time.Parse(time.RFC3339, f[0])
+	a.Elapsed, _ = time.ParseDuration(f[1])
+	a.Status, _ = strconv.Atoi(f[2])
+	return a
+}
+
+// LockSuffix and TempSuffix follow the key in the names of the files
+// locked while checking or stamping the period, and written while
+// replacing the stat file.
+const (
+	LockSuffix = ".lock"
+	TempSuffix = ".new"
+)
+
+// write replaces the stat file with one recording the Record.  The new
+// file is written and synced aside, given its modification time, and
+// then renamed into place, so that the stat file is never seen
+// partially written, even after a crash.  It's called with the stat
+// file locked, so no one else writes aside at the same time.
+func write(statname string, r Record) error {
+	tmp := statname + TempSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(r.encode())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	last := r.Last
+	if last.IsZero() {
+		last = never
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, last, last)
+	}
+	if err == nil {
+		err = os.Rename(tmp, statname)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// lockStat locks the stat file, by locking the lock file named like it
+// followed by LockSuffix, creating that if need be, and returns the
+// lock file.  While it's locked, no one else can check or stamp the
+// period, so that only one of several concurrent invocations decides to
+// run.
+func lockStat(statname string) (*os.File, error) {
+	f, err := os.OpenFile(statname+LockSuffix, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// readLocked reads the Record from the stat file, which is locked, and
+// reports whether the file exists yet.  If it doesn't, the Record is
+// new, created now.
+func readLocked(statname string) (Record, bool, error) {
+	r, err := ReadRecord(statname)
+	if os.IsNotExist(err) {
+		return Record{Created: time.Now()}, false, nil
+	}
+	return r, err == nil, err
+}
+
+// shouldrun reports whether the command should run now, given what the
+// stat file, which is locked, records, recording that it's starting, or
+// else counting a skip.  Unless opts.OnSuccess is set, running stamps
+// the period.
+func shouldrun(statname string, period time.Duration, opts Options) (bool, error) {
+	r, exists, err := readLocked(statname)
+	if err != nil {
+		return false, err
+	}
+	r.Period = period
+	r.Schedule = opts.Schedule
+	r.SameDay = opts.SameDay
+	r.Retry = opts.Retry
+	r, run, skewed := decide(r, exists, time.Now(), ReadClocks(), opts)
+	if skewed && opts.Skew == SkewRun {
+		log.Printf("%s is stamped in the future, so running now; has the clock been set back?\n", statname)
+	} else if skewed {
+		log.Printf("%s is stamped in the future, so counting from now; has the clock been set back?\n", statname)
+	}
+	return run, write(statname, r)
+}
+
+// decide decides whether the command should run as of now, given the
+// Record, whether the stat file exists, and the current clocks,
+// returning the Record updated accordingly.  It also reports whether
+// the Record was stamped in the future, in which case, with SkewRun,
+// the command runs, and otherwise the period is stamped now.
+func decide(r Record, exists bool, now time.Time, clocks Clocks, opts Options) (Record, bool, bool) {
+	a := r.Adjust(now, clocks)
+	skewed := exists && a.Skewed(now)
+	if skewed && opts.Skew == SkewRun {
+		a.Last, a.Failed, a.Created = time.Time{}, time.Time{}, time.Time{}
+	} else if skewed {
+		if r.Last.After(now) {
+			r.stamp(now, clocks, opts)
+		}
+		if r.Failed.After(now) {
+			r.Failed = now
+		}
+		a = r.Adjust(now, clocks)
+	}
+	if now.Before(a.Next(now)) {
+		r.Skips++
+		return r, false, skewed
+	}
+	if !opts.OnSuccess {
+		r.stamp(now, clocks, opts)
+	}
+	r.Start = now
+	r.Skips = 0
+	return r, true, skewed
+}
+
+// skip counts a skip in the stat file, which is locked, for when a
+// previous run is still in progress.
+func skip(statname string, period time.Duration, opts Options) error {
+	r, _, err := readLocked(statname)
+	if err != nil {
+		return err
+	}
+	r.Period = period
+	r.Schedule = opts.Schedule
+	r.SameDay = opts.SameDay
+	r.Retry = opts.Retry
+	r.Skips++
+	return write(statname, r)
+}
+
+// finish records the outcome of the run that started at start in the
+// stat file, given its exit status as for Record.Status, adding it to
+// the history.  With opts.OnSuccess, a successful run stamps the
+// period.
+func finish(statname string, period time.Duration, start time.Time, status int, opts Options) error {
+	f, err := lockStat(statname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, _, err := readLocked(statname)
+	if err != nil {
+		return err
+	}
+	r.Period = period
+	r.Schedule = opts.Schedule
+	r.SameDay = opts.SameDay
+	r.Retry = opts.Retry
+	now := time.Now()
+	if status == 0 {
+		r.Outcome = Success
+		r.Failures = 0
+		r.Failed = time.Time{}
+		if opts.OnSuccess {
+			r.stamp(now, ReadClocks(), opts)
+		}
+	} else {
+		r.Outcome = Failure
+		r.Failures++
+		r.Failed = now
+	}
+	r.Finish = now
+	r.Status = status
+	r.History = append(r.History, Attempt{Start: start, Elapsed: now.Sub(start).Round(time.Millisecond), Status: status})
+	if n := len(r.History) - HistoryLength; n > 0 {
+		r.History = r.History[n:]
+	}
+	return write(statname, r)
+}
+
+// RunSuffix follows the key in the names of the files recording runs in
+// progress.
+const RunSuffix = ".running"
+
+// lockRun locks the run file for the duration of a run.  If a previous
+// run still holds the lock, it returns a nil File.
+func lockRun(runname string) (*os.File, error) {
+	f, err := os.OpenFile(runname, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := tryLockFile(f); !ok {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// startRun records in the locked run file that a run is in progress.
+func startRun(f *os.File) error {
+	data := fmt.Sprintf("pid %d\nstart %s\n", os.Getpid(), time.Now().Format(time.RFC3339))
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(data), 0)
+	return err
+}
+
+// begin decides, with the stat file locked, whether the command should
+// run now, as for shouldrun, and, if it should, records in the run file
+// that a run is in progress, returning the run file locked for the
+// duration of the run.  If a previous run is still in progress, it
+// returns a nil File, unless opts.SkipRunning is set, in which case it
+// counts a skip and fails with exit code 12.  Nothing is recorded in
+// the run file unless the command is to run, so that no run seems to
+// be in progress on behalf of an invocation that doesn't run it.
+func begin(statname, runname string, period time.Duration, opts Options) (*os.File, *cmd.ProcError) {
+	lock, err := lockStat(statname)
+	if err != nil {
+		return nil, &cmd.ProcError{Msg: err.Error(), Code: 11}
+	}
+	defer lock.Close()
+	running, err := lockRun(runname)
+	if err != nil {
+		return nil, &cmd.ProcError{Msg: err.Error(), Code: 11}
+	}
+	if running == nil && opts.SkipRunning {
+		if err := skip(statname, period, opts); err != nil {
+			return nil, &cmd.ProcError{Msg: err.Error(), Code: 11}
+		}
+		msg := "previous run still in progress"
+		if r, ok, _ := ReadRun(runname); ok {
+			msg += ": " + r.String()
+		}
+		return nil, &cmd.ProcError{Msg: msg, Code: 12}
+	}
+	ok, err := shouldrun(statname, period, opts)
+	if err == nil && ok && running != nil {
+		err = startRun(running)
+	}
+	if err != nil || !ok {
+		if running != nil {
+			running.Close()
+		}
+		if err != nil {
+			return nil, &cmd.ProcError{Msg: err.Error(), Code: 11}
+		}
+		return nil, &cmd.ProcError{Code: 10}
+	}
+	return running, nil
+}
+
+// endRun clears the run file and releases its lock.
+func endRun(f *os.File) {
+	f.Truncate(0)
+	f.Close()
+}
+
+// Run describes a run in progress, as recorded in a run file.
+type Run struct {
+	// The process ID of the prunevery process running the command,
+	// and when it started.
+	PID   int
+	Start time.Time
+}
+
+func (r Run) String() string {
+	return fmt.Sprintf("pid %d since %s", r.PID, r.Start.Format("2006-01-02 15:04:05 MST"))
+}
+
+// ReadRun reads the run in progress recorded in the run file at path,
+// if any, reporting whether there is one.  The run file is cleared once
+// the run ends, so a run is in progress as long as the process recorded
+// in it exists.  The run file isn't locked, lest a run starting at the
+// same time take it to be locked by a run in progress.
+func ReadRun(path string) (Run, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Run{}, false, err
+	}
+	var r Run
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) != 2 {
+			continue
+		}
+		switch f[0] {
+		case "pid":
+			r.PID, _ = strconv.Atoi(f[1])
+		case "start":
+			r.Start, _ = time.Parse(time.RFC3339, f[1])
+		}
+	}
+	if r.PID == 0 || !alive(r.PID) {
+		return Run{}, false, nil
+	}
+	return r, true, nil
+}
+
+// Layer returns a cmd.Layer that runs the command only if at least
+// period has elapsed since it was last run, as tracked by the stat file
+// in the state directory named by the given key, or, if opts say so,
+// since it last succeeded, backing off after failures, and, if opts
+// give a schedule, only once per window of it.  If not, it fails with
+// exit code 10.  The outcome of the run is recorded, too.  If there's
+// an error with the stat file, it fails with exit code 11.  If opts say
+// so, and a previous run is still in progress, it fails with exit code
+// 12.  If period is zero, no minimum period is enforced, though a
+// schedule or opts.SkipRunning may still keep the command from running.
+// If opts give an invalid schedule, it fails with exit code 2, as for
+// invalid arguments, rather than ignoring it.
+func Layer(key string, period time.Duration, opts Options) cmd.Layer {
+	return func(next cmd.Runner) cmd.Runner {
+		return func(proc *cmd.Proc) *cmd.ProcError {
+			if opts.Schedule != "" {
+				if _, err := ParseSchedule(opts.Schedule); err != nil {
+					return &cmd.ProcError{Msg: err.Error(), Code: 2}
+				}
+			}
+			if period <= 0 && opts.Schedule == "" && !opts.SkipRunning {
+				return next(proc)
+			}
+			statname, err := proc.KeyPath(StatPrefix, key, "")
+			if err != nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 11}
+			}
+			runname, err := cmd.KeyPath(StatPrefix, key, RunSuffix)
+			if err != nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 11}
+			}
+			running, perr := begin(statname, runname, period, opts)
+			if perr != nil {
+				return perr
+			}
+			if running != nil {
+				defer endRun(running)
+			}
+			start := time.Now()
+			perr = next(proc)
+			status := 0
+			if perr != nil {
+				status = perr.Code
+			}
+			if err := finish(statname, period, start, status, opts); err != nil && perr == nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 11}
+			}
+			return perr
+		}
+	}
+}
+
+func run(s cmd.State) {
+	setup(s)
+	s.Run(Layer(s.Key(), state.period, state.opts))
+}
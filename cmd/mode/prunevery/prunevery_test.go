@@ -0,0 +1,498 @@
+// chris 2026-10-18
+
+package prunevery
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"path/filepath"
+	"sync/atomic"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+func TestNext(t *testing.T) {
+	last := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	r := Record{Last: last, Period: 8 * time.Hour}
+	if next := r.Next(last); !next.Equal(last.Add(8 * time.Hour)) {
+		t.Errorf("next %v\n", next)
+	}
+
+	// Failures back off from the last failure, doubling, up to the
+	// period.
+	failed := last.Add(9 * time.Hour)
+	r = Record{Last: last, Period: 8 * time.Hour, Failures: 1, Failed: failed, Retry: 10 * time.Minute}
+	for _, c := range []struct {
+		failures int
+		backoff  time.Duration
+	}{{1, 10 * time.Minute}, {3, 40 * time.Minute}, {10, 8 * time.Hour}} {
+		r.Failures = c.failures
+		if next := r.Next(failed); !next.Equal(failed.Add(c.backoff)) {
+			t.Errorf("%d failures: next %v, not %v after failing\n", c.failures, next, c.backoff)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prunevery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stat")
+
+	// Stat files written by older versions.
+	if err := ioutil.WriteFile(path, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := ReadRecord(path); err != nil || r.Last.IsZero() || r.Period != 0 {
+		t.Errorf("empty stat file gave %+v, %v\n", r, err)
+	}
+	if err := ioutil.WriteFile(path, []byte("period 1h0m0s\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := ReadRecord(path); err != nil || r.Period != time.Hour {
+		t.Errorf("stat file with period gave %+v, %v\n", r, err)
+	}
+
+	want := Record{
+		Period:   time.Hour,
+		Schedule: "0 2 * * mon-fri",
+		SameDay:  true,
+		Created:  time.Date(2026, 10, 11, 6, 0, 1, 0, time.UTC),
+		Clock:    ClockAwake,
+		Clocks:   Clocks{"6e1b2c0a-4f3d-4c5e-9a1b-2c3d4e5f6a7b", 90 * time.Minute, time.Hour},
+		Outcome:  Failure,
+		Failures: 2,
+		Failed:   time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC),
+		Retry:    time.Minute,
+		Start:    time.Date(2026, 10, 18, 5, 59, 0, 0, time.UTC),
+		Finish:   time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC),
+		Status:   3,
+		Skips:    4,
+		History: []Attempt{
+			{time.Date(2026, 10, 18, 5, 0, 0, 0, time.UTC), 1500 * time.Millisecond, 3},
+			{time.Date(2026, 10, 18, 5, 59, 0, 0, time.UTC), time.Minute, 3},
+		},
+	}
+	if err := write(path, want); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + TempSuffix); !os.IsNotExist(err) {
+		t.Errorf("temporary file left after writing: %v\n", err)
+	}
+	if r, err := ReadRecord(path); err != nil || !reflect.DeepEqual(r, want) {
+		t.Errorf("ReadRecord gave %+v, %v, not %+v\n", r, err, want)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, time.UTC)
+	}
+	// 2026-10-18 is a Sunday.
+	for _, c := range []struct {
+		spec      string
+		after, at time.Time
+	}{
+		{"0 2 * * *", at(18, 1, 30), at(18, 2, 0)},
+		{"0 2 * * *", at(18, 2, 0), at(19, 2, 0)},
+		{"*/15 * * * *", at(18, 6, 7), at(18, 6, 15)},
+		{"0 9 * * mon-fri", at(16, 10, 0), at(19, 9, 0)},
+		{"@weekly", at(14, 10, 0), at(18, 0, 0)},
+		{"0 0 13 * 5", at(18, 0, 0), at(23, 0, 0)},
+	} {
+		s, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Errorf("%s: %v\n", c.spec, err)
+			continue
+		}
+		if next := s.Next(c.after); !next.Equal(c.at) {
+			t.Errorf("%s: next after %v is %v, not %v\n", c.spec, c.after, next, c.at)
+		}
+	}
+	for _, spec := range []string{"60 * * * *", "* * *", "5-1 * * * *", "@often", "0 0 30 2 *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("%s: parsed\n", spec)
+		}
+	}
+
+	r := Record{Last: at(18, 1, 30), Schedule: "0 2 * * *"}
+	if next := r.Next(at(18, 1, 30)); !next.Equal(at(18, 2, 0)) {
+		t.Errorf("next %v\n", next)
+	}
+	r.Period = time.Hour
+	if next := r.Next(at(18, 1, 30)); !next.Equal(at(18, 2, 30)) {
+		t.Errorf("next with period %v\n", next)
+	}
+
+	// A window bounded to its day closes with it, so a missed Friday
+	// isn't made up over the weekend.
+	r = Record{Last: at(15, 10, 0), Schedule: "0 9 * * mon-fri"}
+	if next := r.Next(at(17, 10, 0)); !next.Equal(at(16, 9, 0)) {
+		t.Errorf("next unbounded %v\n", next)
+	}
+	r.SameDay = true
+	if next := r.Next(at(17, 10, 0)); !next.Equal(at(19, 9, 0)) {
+		t.Errorf("next bounded %v\n", next)
+	}
+	if next := r.Next(at(16, 18, 0)); !next.Equal(at(16, 18, 0)) {
+		t.Errorf("next bounded within the day %v\n", next)
+	}
+
+	// A command never run waits for a window beginning after it was
+	// first recorded, or in that minute.
+	r = Record{Created: at(18, 15, 0).Add(30 * time.Second), Schedule: "0 2 * * *"}
+	if next := r.Next(at(18, 15, 1)); !next.Equal(at(19, 2, 0)) {
+		t.Errorf("next when new %v\n", next)
+	}
+	r.Schedule = "0 15 * * *"
+	if next := r.Next(at(18, 15, 1)); !next.Equal(at(18, 15, 0)) {
+		t.Errorf("next when new in a window's minute %v\n", next)
+	}
+}
+
+func TestAdjust(t *testing.T) {
+	now := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	r := Record{
+		// Stamped an hour ago, before the clock was set back two.
+		Last:   now.Add(2 * time.Hour),
+		Period: 8 * time.Hour,
+		Clock:  ClockBoot,
+		Clocks: Clocks{"a", 10 * time.Hour, 9 * time.Hour},
+	}
+	if !r.Skewed(now) {
+		t.Errorf("not skewed\n")
+	}
+	a := r.Adjust(now, Clocks{"a", 11 * time.Hour, 9*time.Hour + 30*time.Minute})
+	if a.Skewed(now) || !a.Last.Equal(now.Add(-time.Hour)) {
+		t.Errorf("adjusted last %v\n", a.Last)
+	}
+	// Only the half hour awake counts.
+	r.Clock = ClockAwake
+	a = r.Adjust(now, Clocks{"a", 11 * time.Hour, 9*time.Hour + 30*time.Minute})
+	if !a.Last.Equal(now.Add(-30 * time.Minute)) {
+		t.Errorf("adjusted last awake %v\n", a.Last)
+	}
+	// The clocks start over on boot.
+	a = r.Adjust(now, Clocks{"b", time.Hour, time.Hour})
+	if !a.Last.Equal(r.Last) {
+		t.Errorf("adjusted last after boot %v\n", a.Last)
+	}
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2026, 10, 18, 6, 0, 0, 0, time.UTC)
+	clocks := Clocks{"a", 10 * time.Hour, 9 * time.Hour}
+	// Stamped an hour ago by the boot clock, during this boot or a
+	// previous one.
+	boot := Clocks{"a", 9 * time.Hour, 8 * time.Hour}
+	before := Clocks{"b", 9 * time.Hour, 8 * time.Hour}
+	for _, c := range []struct {
+		what   string
+		last   time.Time
+		clocks Clocks
+		skew   string
+		// Whether the record is seen as skewed and the command
+		// runs, and when the period is then stamped.
+		skewed, run bool
+		stamped     time.Time
+	}{
+		{"set back during boot", now.Add(2 * time.Hour), boot, SkewClamp, false, false, now.Add(2 * time.Hour)},
+		{"set forward during boot", now.Add(-10 * time.Hour), boot, SkewClamp, false, false, now.Add(-10 * time.Hour)},
+		{"set back across boot, clamped", now.Add(2 * time.Hour), before, SkewClamp, true, false, now},
+		{"set back across boot, run", now.Add(2 * time.Hour), before, SkewRun, true, true, now},
+		{"set forward across boot", now.Add(-10 * time.Hour), before, SkewClamp, false, true, now},
+		{"stamped across boot", now.Add(-time.Hour), before, SkewClamp, false, false, now.Add(-time.Hour)},
+	} {
+		r := Record{Last: c.last, Period: 8 * time.Hour, Clock: ClockBoot, Clocks: c.clocks}
+		got, run, skewed := decide(r, true, now, clocks, Options{Skew: c.skew})
+		if skewed != c.skewed || run != c.run || !got.Last.Equal(c.stamped) {
+			t.Errorf("%s: skewed %v, run %v, stamped %v\n", c.what, skewed, run, got.Last)
+		}
+		if !run && got.Skips != 1 {
+			t.Errorf("%s: skips %d\n", c.what, got.Skips)
+		}
+	}
+
+	// A failure recorded in the future is clamped to now, so the
+	// backoff counts from now.
+	failed := Record{Period: 8 * time.Hour, Outcome: Failure, Failures: 1, Failed: now.Add(time.Hour), Retry: time.Hour}
+	got, run, skewed := decide(failed, true, now, clocks, Options{OnSuccess: true, Retry: time.Hour})
+	if !skewed || run || !got.Failed.Equal(now) || !got.Next(now).Equal(now.Add(time.Hour)) {
+		t.Errorf("failure in the future: skewed %v, run %v, failed %v\n", skewed, run, got.Failed)
+	}
+	// A new record isn't skewed.
+	if _, run, skewed := decide(Record{Period: time.Hour}, false, now, clocks, Options{}); skewed || !run {
+		t.Errorf("new record: skewed %v, run %v\n", skewed, run)
+	}
+}
+
+// testStateDir points the state directory at a new temporary directory,
+// returning a function that removes it again.
+func testStateDir(t *testing.T) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "prunevery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := os.LookupEnv(cmd.StateDirEnv)
+	os.Setenv(cmd.StateDirEnv, dir)
+	return func() {
+		if ok {
+			os.Setenv(cmd.StateDirEnv, old)
+		} else {
+			os.Unsetenv(cmd.StateDirEnv)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// testRun runs prunevery's Layer for key, period, and opts around run,
+// returning what it returns.
+func testRun(key string, period time.Duration, opts Options, run cmd.Runner) *cmd.ProcError {
+	p := cmd.NewProc("true", nil)
+	p.Relay = nil
+	return Layer(key, period, opts)(run)(p)
+}
+
+func TestConcurrent(t *testing.T) {
+	defer testStateDir(t)()
+
+	// Of several invocations at once, just one runs the command.
+	var runs int32
+	run := func(p *cmd.Proc) *cmd.ProcError {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}
+	const n = 8
+	perrs := make(chan *cmd.ProcError, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			perrs <- testRun("k", time.Hour, Options{}, run)
+		}()
+	}
+	skipped := 0
+	for i := 0; i < n; i++ {
+		if perr := <-perrs; perr != nil && perr.Code == 10 {
+			skipped++
+		} else if perr != nil {
+			t.Errorf("invocation exited %+v\n", perr)
+		}
+	}
+	if runs != 1 || skipped != n-1 {
+		t.Errorf("%d of %d invocations ran, and %d skipped\n", runs, n, skipped)
+	}
+}
+
+func TestSkipRunning(t *testing.T) {
+	defer testStateDir(t)()
+
+	// While a run is in progress, even with no period, another is
+	// refused with -skiprunning, but not otherwise.
+	opts := Options{SkipRunning: true}
+	started, release := make(chan bool), make(chan bool)
+	done := make(chan *cmd.ProcError)
+	go func() {
+		done <- testRun("k", 0, opts, func(p *cmd.Proc) *cmd.ProcError {
+			started <- true
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	runname, err := cmd.KeyPath(StatPrefix, "k", RunSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok, err := ReadRun(runname); !ok || r.PID != os.Getpid() {
+		t.Errorf("run in progress read as %+v, %v, %v\n", r, ok, err)
+	}
+	ran := false
+	run := func(p *cmd.Proc) *cmd.ProcError {
+		ran = true
+		return nil
+	}
+	perr := testRun("k", 0, opts, run)
+	if perr == nil || perr.Code != 12 || !strings.Contains(perr.Msg, "previous run still in progress") || ran {
+		t.Errorf("skipping running exited %+v\n", perr)
+	}
+	if perr := testRun("k", 0, Options{}, run); perr != nil || !ran {
+		t.Errorf("running alongside exited %+v\n", perr)
+	}
+	release <- true
+	if perr := <-done; perr != nil {
+		t.Errorf("first run exited %+v\n", perr)
+	}
+	if r, ok, err := ReadRun(runname); ok || err != nil {
+		t.Errorf("finished run read as %+v, %v, %v\n", r, ok, err)
+	}
+
+	ran = false
+	if perr := testRun("k", 0, opts, run); perr != nil || !ran {
+		t.Errorf("skipping running after the first exited %+v\n", perr)
+	}
+}
+
+func TestOnSuccess(t *testing.T) {
+	defer testStateDir(t)()
+	status := 3
+	runs := 0
+	run := func(p *cmd.Proc) *cmd.ProcError {
+		runs++
+		if status != 0 {
+			return &cmd.ProcError{Code: status}
+		}
+		return nil
+	}
+
+	// A failed run isn't stamped, so it's tried again, until it
+	// succeeds.
+	opts := Options{OnSuccess: true}
+	for i := 0; i < 2; i++ {
+		if perr := testRun("k", time.Hour, opts, run); perr == nil || perr.Code != 3 {
+			t.Errorf("failing run exited %+v\n", perr)
+		}
+	}
+	status = 0
+	if perr := testRun("k", time.Hour, opts, run); perr != nil {
+		t.Errorf("succeeding run exited %+v\n", perr)
+	}
+	if perr := testRun("k", time.Hour, opts, run); perr == nil || perr.Code != 10 {
+		t.Errorf("run after success exited %+v\n", perr)
+	}
+	if runs != 3 {
+		t.Errorf("ran %d times, not 3\n", runs)
+	}
+
+	// With a backoff, a failed run isn't retried until it's
+	// elapsed.
+	status, runs = 3, 0
+	opts.Retry = time.Hour
+	for _, code := range []int{3, 10} {
+		if perr := testRun("retry", time.Hour, opts, run); perr == nil || perr.Code != code {
+			t.Errorf("retried run exited %+v, expected %d\n", perr, code)
+		}
+	}
+	statname, err := cmd.KeyPath(StatPrefix, "retry", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := ReadRecord(statname)
+	if err != nil || r.Failures != 1 || r.Outcome != Failure || !r.Last.IsZero() {
+		t.Errorf("failed run recorded %+v, %v\n", r, err)
+	}
+	r.Failed = r.Failed.Add(-2 * time.Hour)
+	if err := write(statname, r); err != nil {
+		t.Fatal(err)
+	}
+	status = 0
+	if perr := testRun("retry", time.Hour, opts, run); perr != nil || runs != 2 {
+		t.Errorf("run after backoff exited %+v\n", perr)
+	}
+
+	// Invocations while it runs run it again, unless told not to.
+	started, release := make(chan bool), make(chan bool)
+	done := make(chan *cmd.ProcError)
+	go func() {
+		done <- testRun("long", time.Hour, Options{OnSuccess: true}, func(p *cmd.Proc) *cmd.ProcError {
+			started <- true
+			<-release
+			return nil
+		})
+	}()
+	<-started
+	runs = 0
+	if perr := testRun("long", time.Hour, Options{OnSuccess: true}, run); perr != nil || runs != 1 {
+		t.Errorf("run alongside exited %+v\n", perr)
+	}
+	if perr := testRun("long", time.Hour, Options{OnSuccess: true, SkipRunning: true}, run); perr == nil || perr.Code != 12 {
+		t.Errorf("run alongside with skiprunning exited %+v\n", perr)
+	}
+	release <- true
+	if perr := <-done; perr != nil {
+		t.Errorf("long run exited %+v\n", perr)
+	}
+}
+
+func TestSkippedNotRunning(t *testing.T) {
+	defer testStateDir(t)()
+	run := func(p *cmd.Proc) *cmd.ProcError {
+		return nil
+	}
+	if perr := testRun("k", time.Hour, Options{}, run); perr != nil {
+		t.Fatalf("first run exited %+v\n", perr)
+	}
+
+	// Invocations that skip running the command never seem to be
+	// running it to those with -skiprunning.
+	const n = 8
+	perrs := make(chan *cmd.ProcError, n*10)
+	for i := 0; i < n; i++ {
+		opts := Options{SkipRunning: i%2 == 0}
+		go func() {
+			for j := 0; j < 10; j++ {
+				perrs <- testRun("k", time.Hour, opts, run)
+			}
+		}()
+	}
+	for i := 0; i < n*10; i++ {
+		if perr := <-perrs; perr == nil || perr.Code != 10 {
+			t.Errorf("invocation exited %+v, expected 10\n", perr)
+		}
+	}
+}
+
+func TestScheduleLayer(t *testing.T) {
+	defer testStateDir(t)()
+	ran := false
+	run := func(p *cmd.Proc) *cmd.ProcError {
+		ran = true
+		return nil
+	}
+
+	// A command never run doesn't run until a window begins.
+	now := time.Now()
+	opts := Options{Schedule: fmt.Sprintf("%d * * * *", (now.Minute()+30)%60)}
+	if perr := testRun("new", 0, opts, run); perr == nil || perr.Code != 10 || ran {
+		t.Errorf("new outside a window exited %+v\n", perr)
+	}
+	opts.Schedule = "* * * * *"
+	if perr := testRun("every", 0, opts, run); perr != nil || !ran {
+		t.Errorf("new in a window exited %+v\n", perr)
+	}
+	ran = false
+	perr := testRun("every", 0, opts, run)
+	if time.Now().Minute() == now.Minute() && (perr == nil || perr.Code != 10 || ran) {
+		t.Errorf("again in the same window exited %+v\n", perr)
+	}
+
+	// Yesterday's window is still open unless bounded to its day.
+	statname, err := cmd.KeyPath(StatPrefix, "sameday", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().AddDate(0, 0, -1).Weekday()
+	opts = Options{Schedule: fmt.Sprintf("0 0 * * %d", yesterday), SameDay: true}
+	if err := write(statname, Record{Last: time.Now().AddDate(0, 0, -8), Schedule: opts.Schedule}); err != nil {
+		t.Fatal(err)
+	}
+	ran = false
+	if perr := testRun("sameday", 0, opts, run); perr == nil || perr.Code != 10 || ran {
+		t.Errorf("bounded to yesterday exited %+v\n", perr)
+	}
+	opts.SameDay = false
+	if perr := testRun("sameday", 0, opts, run); perr != nil || !ran {
+		t.Errorf("unbounded since yesterday exited %+v\n", perr)
+	}
+}
@@ -0,0 +1,203 @@
+// chris 2026-10-18 Calendar-aligned schedules.
+
+package prunevery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a cron-style schedule of the times, in local time, at
+// which windows begin.  The command may run once in each window, at or
+// after the time at which it begins.  A window lasts until the next
+// begins, or, if bounded to its day, until the end of the day on which
+// it begins, if sooner.
+type Schedule struct {
+	spec string
+	// The minutes, hours, days of the month, months, and days of
+	// the week that match, as bit masks.
+	minute, hour, dom, month, dow uint64
+	// Whether the day of the month or of the week is unrestricted.
+	// As with cron, if neither is, a day matches if either does.
+	domAny, dowAny bool
+}
+
+// scheduleField is a field of a schedule.
+type scheduleField struct {
+	min, max int
+	// The names of the values, from min, if they have any.
+	names []string
+}
+
+var scheduleFields = []scheduleField{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// Both 0 and 7 are Sunday.
+	{0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// scheduleMacros are the schedules that may be given by name.
+var scheduleMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// ParseSchedule parses a schedule given as a cron expression, with
+// five fields giving the minute, hour, day of the month, month, and day
+// of the week, or as one of the names @hourly, @daily, @midnight,
+// @weekly, @monthly, @yearly, and @annually.
+func ParseSchedule(spec string) (*Schedule, error) {
+	expr := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		if expr, ok = scheduleMacros[spec]; !ok {
+			return nil, fmt.Errorf("bad schedule: %s", spec)
+		}
+	}
+	f := strings.Fields(expr)
+	if len(f) != len(scheduleFields) {
+		return nil, fmt.Errorf("bad schedule: %s: want %d fields", spec, len(scheduleFields))
+	}
+	s := &Schedule{spec: spec}
+	masks := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, sf := range scheduleFields {
+		mask, err := sf.parse(f[i])
+		if err != nil {
+			return nil, fmt.Errorf("bad schedule: %s: %v", spec, err)
+		}
+		*masks[i] = mask
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(f[2], "*")
+	s.dowAny = strings.HasPrefix(f[4], "*")
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("bad schedule: %s: never begins a window", spec)
+	}
+	return s, nil
+}
+
+// parse parses a field given as a comma-separated list of values,
+// ranges "a-b", or "*", each optionally followed by a step "/n".
+func (sf scheduleField) parse(s string) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step: %s", part)
+			}
+			rng, step = part[:i], n
+		}
+		lo, hi := sf.min, sf.max
+		if rng != "*" {
+			var err error
+			if i := strings.Index(rng, "-"); i >= 0 {
+				if lo, err = sf.value(rng[:i]); err != nil {
+					return 0, err
+				}
+				if hi, err = sf.value(rng[i+1:]); err != nil {
+					return 0, err
+				}
+			} else {
+				if lo, err = sf.value(rng); err != nil {
+					return 0, err
+				}
+				// As with cron, "a/n" means "a-max/n".
+				if step == 1 {
+					hi = lo
+				}
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("bad range: %s", rng)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
+
+// value parses a single value of the field, given as a number or name.
+func (sf scheduleField) value(s string) (int, error) {
+	for i, name := range sf.names {
+		if strings.EqualFold(s, name) {
+			return sf.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < sf.min || v > sf.max {
+		return 0, fmt.Errorf("bad value: %s", s)
+	}
+	return v, nil
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// has reports whether the bit for v is set in mask.
+func has(mask uint64, v int) bool {
+	return mask&(1<<uint(v)) != 0
+}
+
+// day reports whether the schedule matches the day of t.
+func (s *Schedule) day(t time.Time) bool {
+	dom, dow := has(s.dom, t.Day()), has(s.dow, int(t.Weekday()))
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns when the first window after t begins, or the zero Time
+// if none does within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.In(time.Local).Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case !has(s.month, int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.Local)
+		case !s.day(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.Local)
+		case !has(s.hour, t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, time.Local)
+		case !has(s.minute, t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// open returns when, at or after t, a window that began after the time
+// after is open: t itself, if one is, or else when the next such window
+// begins.  If day is set, windows are bounded to the day on which they
+// begin.  If no window begins within five years of after, it returns t.
+func (s *Schedule) open(after, t time.Time, day bool) time.Time {
+	if day {
+		t := t.In(time.Local)
+		midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+		if d := midnight.Add(-time.Nanosecond); d.After(after) {
+			after = d
+		}
+	}
+	if begins := s.Next(after); begins.After(t) {
+		return begins
+	}
+	return t
+}
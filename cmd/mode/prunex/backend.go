@@ -0,0 +1,122 @@
+// chris 2026-10-18 Lock backends.
+
+package prunex
+
+import (
+	"fmt"
+	"strings"
+
+	"path/filepath"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// Backend acquires locks named by keys on behalf of their holders.
+type Backend interface {
+	// TryLock acquires the lock named by key for the holder without
+	// waiting.  If others hold the lock, the error is a *HeldError
+	// describing them.
+	TryLock(key string, h Holder) (Lock, error)
+}
+
+// Lock is a lock held through a Backend.
+type Lock interface {
+	// Unlock releases the lock.
+	Unlock() error
+	// Lost returns a channel that receives an error if the lock is
+	// lost while it's held, as when a lease is taken over, or nil if
+	// the lock can't be lost.
+	Lost() <-chan error
+}
+
+// splitBackend splits the specification of a backend, as for
+// NewBackend, into its kind and its argument, checking that it's valid.
+func splitBackend(spec string) (kind, arg string, err error) {
+	if spec == "" {
+		return "flock", "", nil
+	}
+	kind = spec
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, arg = spec[:i], spec[i+1:]
+	}
+	switch {
+	case kind == "flock" && arg == "":
+	case kind == "lease" && arg != "":
+	case kind == "tcp" && arg != "":
+	default:
+		return "", "", fmt.Errorf("bad backend: %s", spec)
+	}
+	return kind, arg, nil
+}
+
+// NewBackend returns the backend given by opts.Backend, which is one
+// of:
+//
+//	flock          lock files in the state directory, or opts.Path,
+//	               locked with flock(2), the default if empty
+//	lease:dir      lease files in the directory dir, renewed within
+//	               opts.Lease
+//	tcp:address    a Server listening at the TCP address
+func NewBackend(opts Options) (Backend, error) {
+	kind, arg, err := splitBackend(opts.Backend)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "lease":
+		lease := opts.Lease
+		if lease == 0 {
+			lease = DefaultLease
+		}
+		return &leaseBackend{dir: arg, lease: lease}, nil
+	case "tcp":
+		return &tcpBackend{addr: arg}, nil
+	}
+	dir, err := cmd.StateDir()
+	if err != nil {
+		return nil, err
+	}
+	return &flockBackend{
+		dir:        dir,
+		globalname: filepath.Join(dir, fmt.Sprintf("%s_global", name)),
+		path:       opts.Path,
+	}, nil
+}
+
+// flockBackend locks command-specific lock files in the state
+// directory, or a path, with flock(2), creating and removing them under
+// the global lock.
+type flockBackend struct {
+	dir, globalname string
+	// The file or directory to lock, if not empty.
+	path string
+}
+
+// TryLock locks the command-specific lock file named by the key, or the
+// path, recording the holder.
+//
+// A lock whose recorded holders no longer exist is reported as stale,
+// but never broken: since the lock is still held, some process, such as
+// a command given the lock with PassFD, keeps it open, and would
+// otherwise run alongside the next holder.
+func (b *flockBackend) TryLock(key string, h Holder) (Lock, error) {
+	localname := filepath.Join(b.dir, LockPrefix+key)
+	l, err := tryLock(b.globalname, localname, b.path, h)
+	if err == nil {
+		return l, nil
+	} else if err != errLocked {
+		return nil, err
+	}
+	holders := holdersOf(localname)
+	return nil, &HeldError{Holders: holders, Stale: stale(holders)}
+}
+
+// holdersOf returns the holders recorded in the lock file
+// localname, or an unknown holder if they can't be read.
+func holdersOf(localname string) []Holder {
+	holders, err := ReadHolders(localname)
+	if err != nil {
+		return []Holder{{}}
+	}
+	return holders
+}
@@ -0,0 +1,256 @@
+// chris 2026-10-18
+
+package prunex
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"path/filepath"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// testStateDir points the state directory at a new temporary directory,
+// returning it and a function that removes it again.
+func testStateDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "prunex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := os.LookupEnv(cmd.StateDirEnv)
+	os.Setenv(cmd.StateDirEnv, dir)
+	return dir, func() {
+		if ok {
+			os.Setenv(cmd.StateDirEnv, old)
+		} else {
+			os.Unsetenv(cmd.StateDirEnv)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// testRun runs the command through prunex's Layer for key and opts,
+// returning what it returns.
+func testRun(key string, opts Options, command string, args ...string) *cmd.ProcError {
+	p := cmd.NewProc(command, args)
+	p.Relay = nil
+	return cmd.Stack(Layer(key, opts))(p)
+}
+
+// testExpect checks that running true as prunex would with opts exits
+// with code, and with a message containing msg.
+func testExpect(t *testing.T, what, key string, opts Options, code int, msg string) {
+	t.Helper()
+	perr := testRun(key, opts, "true")
+	switch {
+	case perr == nil && code == 0:
+	case perr == nil:
+		t.Errorf("%s succeeded, expected exit %d\n", what, code)
+	case perr.Code != code || !strings.Contains(perr.Msg, msg):
+		t.Errorf("%s exited %d (message %q), expected %d (message %q)\n", what, perr.Code, perr.Msg, code, msg)
+	}
+}
+
+// testBackend checks that the backend excludes a second holder of the
+// lock while the first holds it, returning the first's lock.
+func testBackend(t *testing.T, b Backend) Lock {
+	t.Helper()
+	first, second := Holder{PID: 1, Host: "a"}, Holder{PID: 2, Host: "b"}
+	l, err := b.TryLock("k", first)
+	if err != nil {
+		t.Fatalf("first lock errored: %v\n", err)
+	}
+	_, err = b.TryLock("k", second)
+	if he, ok := err.(*HeldError); !ok || len(he.Holders) != 1 || he.Holders[0] != first {
+		t.Fatalf("second lock errored: %v\n", err)
+	}
+	other, err := b.TryLock("other", second)
+	if err != nil {
+		t.Fatalf("other lock errored: %v\n", err)
+	}
+	other.Unlock()
+	return l
+}
+
+func TestLease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prunex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	b := &leaseBackend{dir: dir, lease: 300 * time.Millisecond}
+
+	l := testBackend(t, b)
+	time.Sleep(500 * time.Millisecond)
+	if _, err := b.TryLock("k", Holder{PID: 2}); err == nil {
+		t.Fatalf("renewed lease was taken over\n")
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("unlock errored: %v\n", err)
+	}
+
+	// An expired lease is taken over, and its holder loses it.
+	old := &leaseLock{
+		name:  filepath.Join(dir, LeasePrefix+"k"),
+		lease: lease{holder: Holder{PID: 1}, token: "old", length: 300 * time.Millisecond},
+	}
+	if err := old.create(); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Second)
+	os.Chtimes(old.name, past, past)
+	l, err = b.TryLock("k", Holder{PID: 2})
+	if err != nil {
+		t.Fatalf("expired lease wasn't taken over: %v\n", err)
+	}
+	if err := old.check(); err != errLost {
+		t.Errorf("check of lost lease gave %v\n", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Errorf("unlock of taken over lease errored: %v\n", err)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	go NewServer().Serve(ln)
+	b := &tcpBackend{addr: ln.Addr().String()}
+
+	l := testBackend(t, b)
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("unlock errored: %v\n", err)
+	}
+	// The server releases the lock once it notices the disconnection.
+	for i := 0; ; i++ {
+		l, err = b.TryLock("k", Holder{PID: 2})
+		if err == nil {
+			break
+		} else if i == 100 {
+			t.Fatalf("lock after unlock errored: %v\n", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	l.Unlock()
+
+	if _, err := b.TryLock("bad key", Holder{}); err == nil {
+		t.Errorf("bad key was locked\n")
+	}
+
+	// The slots of a key as long as allowed may be locked, too.
+	long := strings.Repeat("k", cmd.MaxKeyLength)
+	l, err = b.TryLock(SlotKey(long, 2), Holder{})
+	if err != nil {
+		t.Fatalf("lock of slot of long key errored: %v\n", err)
+	}
+	l.Unlock()
+	if _, err := b.TryLock(long+"k"+slotPrefix+"2", Holder{}); err == nil {
+		t.Errorf("slot of too long key was locked\n")
+	}
+}
+
+// testLost checks that running a command through prunex's Layer for
+// key and opts stops it once lose has made it lose the lock.
+func testLost(t *testing.T, what, key string, opts Options, lose func()) {
+	t.Helper()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		lose()
+	}()
+	start := time.Now()
+	perr := testRun(key, opts, "sleep", "10")
+	if perr == nil || perr.Code != 21 {
+		t.Errorf("%s: command exited %+v, expected 21\n", what, perr)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("%s: command ran for %s after losing the lock\n", what, d)
+	}
+}
+
+func TestLeaseLost(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	opts := Options{Backend: "lease:" + dir, Lease: 150 * time.Millisecond}
+	name := filepath.Join(dir, LeasePrefix+"k")
+
+	testLost(t, "lease moved aside", "k", opts, func() {
+		os.Rename(name, name+".aside")
+	})
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("lost lease recreated: %v\n", err)
+	}
+
+	// A long lease is checked more often than it's renewed.
+	opts.Lease = time.Minute
+	testLost(t, "long lease moved aside", "k", opts, func() {
+		os.Rename(name, name+".aside")
+	})
+}
+
+func TestLeaseTakeOverRace(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	name := filepath.Join(dir, LeasePrefix+"k")
+
+	// A lease renewed after it was seen to have expired is put back,
+	// unless someone else created the lease file meanwhile.
+	live := lease{holder: Holder{PID: 1}, token: "live", length: time.Minute}
+	if err := ioutil.WriteFile(name, live.encode(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := takeOver(name, live, "taker"); err != nil {
+		t.Errorf("take over of live lease errored: %v\n", err)
+	}
+	if got, err := readLease(name); err != nil || got.token != "live" {
+		t.Errorf("live lease not put back: %+v, %v\n", got, err)
+	}
+	aside := name + ".expired.taker"
+	if err := os.Rename(name, aside); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte("token third\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := restore(aside, name); err == nil {
+		t.Errorf("put back over another lease\n")
+	} else if _, ok := err.(*HeldError); !ok {
+		t.Errorf("put back over another lease errored: %v\n", err)
+	}
+}
+
+func TestTCPLost(t *testing.T) {
+	_, done := testStateDir(t)
+	defer done()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	// The server grants the lock, but then goes away.
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if _, _, err := readRequest(bufio.NewReader(conn)); err == nil {
+			conn.Write([]byte("ok\n"))
+		}
+		conns <- conn
+	}()
+	opts := Options{Backend: "tcp:" + ln.Addr().String()}
+
+	testLost(t, "server gone", "k", opts, func() {
+		(<-conns).Close()
+	})
+}
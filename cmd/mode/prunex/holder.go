@@ -0,0 +1,200 @@
+// chris 2026-10-18 Lock holder metadata.
+
+package prunex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"io/ioutil"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// Holder describes the holder of a lock, as recorded in its lock file.
+type Holder struct {
+	// The process ID and host name of the prunex process holding
+	// the lock.
+	PID  int
+	Host string
+	// When the lock was acquired.
+	Start time.Time
+	// The command being run while holding the lock.
+	Command string
+	// Whether the lock is shared with other holders, rather than
+	// held exclusively.
+	Shared bool
+}
+
+// newHolder returns the Holder describing the current process running
+// the command.
+func newHolder(proc *cmd.Proc) Holder {
+	host, _ := os.Hostname()
+	return Holder{
+		PID:     os.Getpid(),
+		Host:    host,
+		Start:   time.Now(),
+		Command: proc.String(),
+	}
+}
+
+// String describes the holder, such as
+//
+//	pid 4242 on web1 since 2026-10-18 06:00:01 UTC: sh sync.sh
+//
+// leaving out whatever isn't known.
+func (h Holder) String() string {
+	if h.PID == 0 {
+		return "unknown holder"
+	}
+	s := fmt.Sprintf("pid %d", h.PID)
+	if h.Host != "" {
+		s += " on " + h.Host
+	}
+	if !h.Start.IsZero() {
+		s += " since " + h.Start.Format("2006-01-02 15:04:05 MST")
+	}
+	if h.Command != "" {
+		s += ": " + h.Command
+	}
+	return s
+}
+
+// Stale reports whether the holder is known to no longer exist: its
+// process is on this host, but there's no such process.
+func (h Holder) Stale() bool {
+	if h.PID == 0 || h.Host == "" {
+		return false
+	}
+	if host, err := os.Hostname(); err != nil || host != h.Host {
+		return false
+	}
+	return !alive(h.PID)
+}
+
+// same reports whether h and o describe the same holder, even if they
+// hold the lock from the same process, as several goroutines might.
+func (h Holder) same(o Holder) bool {
+	return h.PID == o.PID && h.Host == o.Host && h.Start.Equal(o.Start)
+}
+
+// mode returns the mode in which the holder holds the lock.
+func (h Holder) mode() string {
+	if h.Shared {
+		return "shared"
+	}
+	return "exclusive"
+}
+
+// encode returns the Holder as recorded in a lock file: one "name
+// value" line per field.  The command is quoted, as by strconv.Quote,
+// since its arguments may contain newlines.
+func (h Holder) encode() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "pid %d\n", h.PID)
+	fmt.Fprintf(&b, "host %s\n", h.Host)
+	fmt.Fprintf(&b, "start %s\n", h.Start.Format(time.RFC3339Nano))
+	fmt.Fprintf(&b, "command %s\n", strconv.Quote(h.Command))
+	fmt.Fprintf(&b, "mode %s\n", h.mode())
+	return b.Bytes()
+}
+
+// encodeHolders returns the holders as recorded in a lock file, each
+// separated from the next by a blank line.
+func encodeHolders(holders []Holder) []byte {
+	var b bytes.Buffer
+	for i, h := range holders {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.Write(h.encode())
+	}
+	return b.Bytes()
+}
+
+// parseHolder parses the Holder recorded in a lock file.  Unknown or
+// malformed fields are ignored, and a lone process ID is also accepted.
+func parseHolder(data []byte) Holder {
+	var h Holder
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		h.PID = pid
+		return h
+	}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.SplitN(sc.Text(), " ", 2)
+		if len(f) != 2 {
+			continue
+		}
+		switch f[0] {
+		case "pid":
+			h.PID, _ = strconv.Atoi(f[1])
+		case "host":
+			h.Host = f[1]
+		case "start":
+			h.Start, _ = time.Parse(time.RFC3339, f[1])
+		case "command":
+			// Older versions recorded it unquoted.
+			if c, err := strconv.Unquote(f[1]); err == nil {
+				h.Command = c
+			} else {
+				h.Command = f[1]
+			}
+		case "mode":
+			h.Shared = f[1] == "shared"
+		}
+	}
+	return h
+}
+
+// parseHolders parses the holders recorded in a lock file, as for
+// encodeHolders.  There's always at least one, though it may be
+// unknown.
+func parseHolders(data []byte) []Holder {
+	var holders []Holder
+	for _, rec := range bytes.Split(data, []byte("\n\n")) {
+		if len(bytes.TrimSpace(rec)) > 0 {
+			holders = append(holders, parseHolder(rec))
+		}
+	}
+	if len(holders) == 0 {
+		holders = append(holders, Holder{})
+	}
+	return holders
+}
+
+// ReadHolder returns the Holder recorded in the lock file at path.  If
+// the lock is shared, it's the first of the holders.  Lock files exist
+// only while they're held, unless their holder failed to remove them.
+func ReadHolder(path string) (Holder, error) {
+	holders, err := ReadHolders(path)
+	if err != nil {
+		return Holder{}, err
+	}
+	return holders[0], nil
+}
+
+// ReadHolders returns the holders recorded in the lock file at path:
+// just one, unless the lock is shared.
+func ReadHolders(path string) ([]Holder, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseHolders(data), nil
+}
+
+// stale reports whether all the holders are stale.
+func stale(holders []Holder) bool {
+	for _, h := range holders {
+		if !h.Stale() {
+			return false
+		}
+	}
+	return len(holders) > 0
+}
@@ -0,0 +1,52 @@
+// chris 2026-10-18
+
+package prunex
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHolder(t *testing.T) {
+	h := Holder{
+		PID:     4242,
+		Host:    "web1",
+		Start:   time.Date(2026, 10, 18, 6, 0, 1, 0, time.UTC),
+		Command: "sh sync.sh",
+	}
+	if got := parseHolder(h.encode()); got != h {
+		t.Errorf("parseHolder(encode(%+v)) != itself (got %+v)\n", h, got)
+	}
+	h.Command = "sh -c \"echo a\n\necho b\""
+	if got := parseHolder(h.encode()); got != h {
+		t.Errorf("parseHolder(encode(%+v)) != itself (got %+v)\n", h, got)
+	}
+	if got := parseHolder([]byte("pid 4242\ncommand sh sync.sh\n")); got.Command != "sh sync.sh" {
+		t.Errorf("parseHolder of unquoted command gave %+v\n", got)
+	}
+	if got := parseHolder([]byte("4242\n")); got.PID != 4242 {
+		t.Errorf("parseHolder of lone pid gave %+v\n", got)
+	}
+
+	h.Shared = true
+	h2 := h
+	h2.PID++
+	if got := parseHolders(encodeHolders([]Holder{h, h2})); len(got) != 2 || got[0] != h || got[1] != h2 {
+		t.Errorf("parseHolders(encodeHolders(%+v, %+v)) gave %+v\n", h, h2, got)
+	}
+	if got := parseHolders(nil); len(got) != 1 || got[0] != (Holder{}) {
+		t.Errorf("parseHolders of nothing gave %+v\n", got)
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		t.Skip(err)
+	}
+	if (Holder{PID: os.Getpid(), Host: host}).Stale() {
+		t.Errorf("own process is stale\n")
+	}
+	if (Holder{PID: os.Getpid(), Host: host + ".elsewhere"}).Stale() {
+		t.Errorf("process on another host is stale\n")
+	}
+}
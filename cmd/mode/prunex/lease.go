@@ -0,0 +1,289 @@
+// chris 2026-10-18 Lease files on a shared file system.
+
+package prunex
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// LeasePrefix prefixes the names of lease files, which are followed by
+// the key.
+const LeasePrefix = name + "_lease_"
+
+// DefaultLease is how long a lease lasts unless renewed, by default.
+const DefaultLease = 30 * time.Second
+
+// leaseCheck is how often, at most, a holder checks that it still holds
+// its lease, which may be more often than it renews it.
+const leaseCheck = time.Second
+
+// errLost is returned when a lease has been taken over by someone else.
+var errLost = errors.New("lease taken over")
+
+// leaseBackend locks by means of lease files in a directory, typically
+// on a file system shared between hosts.  A lease file is created
+// atomically, by linking it into place, and its holder renews it by
+// updating its modification time.  Once it hasn't been renewed for the
+// length of the lease, it has expired, and anyone may take it over.
+// Expiry compares the file's modification time, as set by the host that
+// last renewed it, with the local clock, so clocks skewed between hosts
+// by anything like the length of the lease defeat it.
+type leaseBackend struct {
+	dir   string
+	lease time.Duration
+}
+
+// lease is a lease as recorded in a lease file: the holder's fields,
+// followed by "token" and "lease" lines.  It was last renewed at the
+// file's modification time.
+type lease struct {
+	holder Holder
+	// A random token distinguishing the lease from any other.
+	token string
+	// How long the lease lasts unless renewed, and when it last was.
+	length  time.Duration
+	renewed time.Time
+}
+
+// expired reports whether the lease hasn't been renewed for its length,
+// by the local clock.
+func (l lease) expired() bool {
+	return time.Since(l.renewed) > l.length
+}
+
+// encode returns the lease as recorded in a lease file.
+func (l lease) encode() []byte {
+	b := l.holder.encode()
+	return append(b, fmt.Sprintf("token %s\nlease %s\n", l.token, l.length)...)
+}
+
+// readLease returns the lease recorded in the lease file at path.  A
+// lease without a length lasts DefaultLease.
+func readLease(path string) (lease, error) {
+	var l lease
+	f, err := os.Open(path)
+	if err != nil {
+		return l, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return l, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return l, err
+	}
+	l.holder = parseHolder(data)
+	l.length = DefaultLease
+	l.renewed = fi.ModTime()
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.SplitN(sc.Text(), " ", 2)
+		if len(f) != 2 {
+			continue
+		}
+		switch f[0] {
+		case "token":
+			l.token = f[1]
+		case "lease":
+			if d, err := time.ParseDuration(f[1]); err == nil && d > 0 {
+				l.length = d
+			}
+		}
+	}
+	return l, nil
+}
+
+// ReadLease returns the Holder recorded in the lease file at path and
+// when the lease expires unless renewed.
+func ReadLease(path string) (Holder, time.Time, error) {
+	l, err := readLease(path)
+	return l.holder, l.renewed.Add(l.length), err
+}
+
+// newToken returns a new random token.
+func newToken() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d.%d", os.Getpid(), time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// TryLock creates the lease file named by the key, recording the
+// holder, taking it over if it has expired.
+func (b *leaseBackend) TryLock(key string, h Holder) (Lock, error) {
+	l := &leaseLock{
+		name:    filepath.Join(b.dir, LeasePrefix+key),
+		lease:   lease{holder: h, token: newToken(), length: b.lease},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		lost:    make(chan error, 1),
+	}
+	// Others may be creating and taking over the lease file at the
+	// same time, so give up on it after a few tries.
+	for i := 0; i < 3; i++ {
+		err := l.create()
+		if err == nil {
+			go l.renew()
+			return l, nil
+		} else if !os.IsExist(err) {
+			return nil, err
+		}
+		other, err := readLease(l.name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !other.expired() {
+			return nil, &HeldError{Holders: []Holder{other.holder}}
+		}
+		log.Printf("taking over lease held by %s, expired since %s\n", other.holder, other.renewed.Add(other.length).Format("2006-01-02 15:04:05 MST"))
+		if err := takeOver(l.name, other, l.lease.token); err != nil {
+			return nil, err
+		}
+	}
+	return nil, &HeldError{Holders: []Holder{{}}}
+}
+
+// takeOver removes the lease file at name, which held the expired lease
+// seen, so that it may be created afresh.  It's first moved aside,
+// atomically, so that only one of those taking it over removes it.  If
+// it turns out to have been renewed or replaced in the meantime, it's
+// put back.  If it can't be, because someone created the lease file
+// while it was aside, the race is lost, and takeOver returns a
+// *HeldError.  The lease's holder then finds that it has lost the
+// lease the next time it checks.
+func takeOver(name string, seen lease, token string) error {
+	aside := name + ".expired." + token
+	if err := os.Rename(name, aside); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer os.Remove(aside)
+	got, err := readLease(aside)
+	if err != nil {
+		return err
+	}
+	if got.token != seen.token || !got.expired() {
+		return restore(aside, name)
+	}
+	return nil
+}
+
+// restore puts the lease file moved aside back at name, returning a
+// *HeldError naming the new holder if someone created it meanwhile.
+func restore(aside, name string) error {
+	err := os.Link(aside, name)
+	if os.IsExist(err) {
+		other, _ := readLease(name)
+		return &HeldError{Holders: []Holder{other.holder}}
+	}
+	return err
+}
+
+// leaseLock is a held lease.
+type leaseLock struct {
+	name  string
+	lease lease
+	// done is closed to stop renewing the lease, and stopped is
+	// closed once renewing has stopped.
+	done, stopped chan struct{}
+	// lost receives why the lease was lost, if it was.
+	lost chan error
+}
+
+// create creates the lease file, failing if it exists.  It's written
+// aside and then linked into place, so that it's never seen partially
+// written, even on file systems such as NFS that don't support
+// exclusive creation.
+func (l *leaseLock) create() error {
+	tmp := l.name + ".new." + l.lease.token
+	if err := ioutil.WriteFile(tmp, l.lease.encode(), 0666); err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Link(tmp, l.name)
+}
+
+// check returns errLost if the lease file no longer records the lease.
+func (l *leaseLock) check() error {
+	got, err := readLease(l.name)
+	if os.IsNotExist(err) || err == nil && got.token != l.lease.token {
+		return errLost
+	}
+	return err
+}
+
+// renew renews the lease three times per its length until it's
+// released or lost: taken over, or not renewed for its length, after
+// which anyone may take it over.  It checks that the lease hasn't been
+// taken over at least every leaseCheck.
+func (l *leaseLock) renew() {
+	defer close(l.stopped)
+	period := l.lease.length / 3
+	if period <= 0 {
+		period = 1
+	}
+	tick := period
+	if tick > leaseCheck {
+		tick = leaseCheck
+	}
+	t := time.NewTicker(tick)
+	defer t.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-t.C:
+		}
+		err := l.check()
+		if err == nil && time.Since(renewed) >= period {
+			now := time.Now()
+			if err = os.Chtimes(l.name, now, now); err == nil {
+				renewed = now
+			}
+		}
+		if err == errLost {
+			l.lost <- err
+			return
+		} else if err != nil && time.Since(renewed) > l.lease.length {
+			l.lost <- fmt.Errorf("lease expired: %v", err)
+			return
+		} else if err != nil {
+			log.Printf("could not renew lease %s: %v\n", l.name, err)
+		}
+	}
+}
+
+// Lost returns a channel that receives an error once the lease is lost.
+func (l *leaseLock) Lost() <-chan error {
+	return l.lost
+}
+
+// Unlock stops renewing the lease and removes the lease file, unless
+// the lease has been lost.
+func (l *leaseLock) Unlock() error {
+	close(l.done)
+	<-l.stopped
+	if err := l.check(); err != nil {
+		return err
+	}
+	return os.Remove(l.name)
+}
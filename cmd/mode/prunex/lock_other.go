@@ -0,0 +1,39 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package prunex
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by tryLock when someone else holds the lock.
+var errLocked = errors.New("locked")
+
+var errNoLock = errors.New("locking not supported")
+
+// lock is a held lock on a command-specific lock file, or on a path.
+type lock struct {
+	file *os.File
+}
+
+// tryLock returns an error: locking is supported on Unix-like systems
+// only.
+func tryLock(globalname, localname, path string, h Holder) (*lock, error) {
+	return nil, errNoLock
+}
+
+func (l *lock) Unlock() error {
+	return errNoLock
+}
+
+func (l *lock) Lost() <-chan error {
+	return nil
+}
+
+// alive reports that the process exists, since it can't be determined.
+func alive(pid int) bool {
+	return true
+}
@@ -0,0 +1,245 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package prunex
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// errLocked is returned by tryLock when someone else holds the lock.
+var errLocked = errors.New("locked")
+
+// lock is a held lock on a command-specific lock file, or on a path,
+// with its holders recorded in a command-specific lock file.
+type lock struct {
+	globalname string
+	file       *os.File
+	records    *os.File
+	holder     Holder
+}
+
+// flock applies or removes a lock on the file, retrying if interrupted.
+func flock(f *os.File, how int) error {
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// withGlobal calls fn while holding the global lock, so that
+// command-specific lock files are created, locked, and removed without
+// racing each other.
+func withGlobal(globalname string, fn func() error) error {
+	g, err := os.OpenFile(globalname, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+	if err := flock(g, syscall.LOCK_EX); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// tryLock locks the command-specific lock file localname without
+// waiting, recording the holder in it.  If path isn't empty, the file
+// or directory there is locked instead, and the holder is still
+// recorded in localname.  The lock is shared if the holder says so.  If
+// someone else holds the lock exclusively, or at all if the holder
+// wants it exclusively, it returns errLocked.
+func tryLock(globalname, localname, path string, h Holder) (*lock, error) {
+	var l *lock
+	err := withGlobal(globalname, func() error {
+		records, err := os.OpenFile(localname, os.O_RDWR|os.O_CREATE, 0666)
+		if err != nil {
+			return err
+		}
+		f := records
+		if path != "" {
+			if f, err = openPath(path); err != nil {
+				records.Close()
+				return err
+			}
+		}
+		if h.Shared {
+			err = lockShared(f, records, h)
+		} else {
+			err = lockExclusive(f, records, h)
+		}
+		if err != nil {
+			if f != records {
+				removeEmpty(records)
+			}
+			(&lock{file: f, records: records}).close()
+			if err == syscall.EWOULDBLOCK {
+				return errLocked
+			}
+			return err
+		}
+		l = &lock{globalname: globalname, file: f, records: records, holder: h}
+		return nil
+	})
+	return l, err
+}
+
+// openPath opens the file or directory at path in order to lock it,
+// creating an empty file if there's nothing there.
+func openPath(path string) (*os.File, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		f, err = os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0666)
+	}
+	return f, err
+}
+
+// removeEmpty removes the lock file if it records no holders, as when
+// it was just created to record the holders of a path that turned out
+// to be locked by someone else, such as flock(1).
+func removeEmpty(records *os.File) {
+	if fi, err := records.Stat(); err == nil && fi.Size() == 0 {
+		os.Remove(records.Name())
+	}
+}
+
+// lockExclusive locks the file f exclusively, recording just the holder
+// in the lock file records.
+func lockExclusive(f, records *os.File, h Holder) error {
+	if err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return err
+	}
+	return record(records, []Holder{h})
+}
+
+// lockShared locks the file f shared, adding the holder to those
+// recorded in the lock file records.  If no one else holds the lock,
+// any holders still recorded are long gone, so they're discarded.
+//
+// Since it's called with the global lock held, no other prunex can lock
+// the file in between locking it exclusively and sharing it.  But
+// flock(2) doesn't convert locks atomically, so someone locking it
+// otherwise, such as flock(1), might, and then sharing it fails without
+// waiting, as if it had been locked all along, rather than blocking
+// with the global lock held.
+func lockShared(f, records *os.File, h Holder) error {
+	err := flock(f, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == nil {
+		if err := record(records, []Holder{h}); err != nil {
+			return err
+		}
+		if err := flock(f, syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+			record(records, nil)
+			return err
+		}
+		return nil
+	}
+	if err != syscall.EWOULDBLOCK {
+		return err
+	}
+	if err := flock(f, syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		return err
+	}
+	holders, err := readHolders(records)
+	if err != nil {
+		return err
+	}
+	return record(records, append(holders, h))
+}
+
+// readHolders returns the holders recorded in the lock file.
+func readHolders(f *os.File) ([]Holder, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, fi.Size())
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	return parseHolders(data), nil
+}
+
+// record records the holders in the lock file.
+func record(f *os.File, holders []Holder) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(encodeHolders(holders), 0)
+	return err
+}
+
+// Unlock releases the lock.  The lock file is removed, unless others
+// still share the lock, in which case just its holder is removed from
+// those recorded in it.  A locked path is left alone, since the
+// command it was passed to might still hold it, and its lock file is
+// removed once no holders are recorded in it.
+func (l *lock) Unlock() error {
+	return withGlobal(l.globalname, func() error {
+		var err error
+		switch {
+		case l.records != l.file:
+			var n int
+			if n, err = l.unrecord(); err == nil && n == 0 {
+				err = os.Remove(l.records.Name())
+			}
+		case l.holder.Shared && flock(l.file, syscall.LOCK_EX|syscall.LOCK_NB) == syscall.EWOULDBLOCK:
+			_, err = l.unrecord()
+		default:
+			err = os.Remove(l.records.Name())
+		}
+		if cerr := l.close(); err == nil {
+			err = cerr
+		}
+		return err
+	})
+}
+
+// Lost returns nil: a lock held with flock(2) is held until it's
+// released.
+func (l *lock) Lost() <-chan error {
+	return nil
+}
+
+// close closes the locked file and the lock file, if they differ.
+func (l *lock) close() error {
+	err := l.file.Close()
+	if l.records != l.file {
+		if rerr := l.records.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// unrecord removes the lock's holder from those recorded in the lock
+// file, along with any others that no longer exist, returning how many
+// remain.
+func (l *lock) unrecord() (int, error) {
+	holders, err := readHolders(l.records)
+	if err != nil {
+		return 0, err
+	}
+	var rest []Holder
+	for _, h := range holders {
+		if !h.same(l.holder) && !h.Stale() {
+			rest = append(rest, h)
+		}
+	}
+	return len(rest), record(l.records, rest)
+}
+
+// alive reports whether the process with the given ID exists.
+func alive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,246 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package prunex
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// testHold holds the lock named by key, as prunex would with opts, on
+// behalf of the current process.
+func testHold(t *testing.T, key string, opts Options) Lock {
+	t.Helper()
+	b, err := NewBackend(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHolder(cmd.NewProc("holder", nil))
+	h.Shared = opts.Shared
+	l, err := acquire(b, slotKeys(key, opts.Slots), h)
+	if err != nil {
+		t.Fatalf("holding %s errored: %v\n", key, err)
+	}
+	return l
+}
+
+func TestFlockContention(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	localname := filepath.Join(dir, LockPrefix+"k")
+
+	l := testHold(t, "k", Options{})
+	holders, err := ReadHolders(localname)
+	if err != nil || len(holders) != 1 || holders[0].PID != os.Getpid() {
+		t.Errorf("held lock records %+v (error %v)\n", holders, err)
+	}
+	testExpect(t, "contending", "k", Options{}, 20, "locked by pid")
+	testExpect(t, "other key", "other", Options{}, 0, "")
+	if err := l.Unlock(); err != nil {
+		t.Fatalf("unlock errored: %v\n", err)
+	}
+	if _, err := os.Stat(localname); !os.IsNotExist(err) {
+		t.Errorf("lock file left after unlock: %v\n", err)
+	}
+	testExpect(t, "after unlock", "k", Options{}, 0, "")
+
+	// A lock held on behalf of a holder that no longer exists is
+	// reported as stale, but isn't broken.
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Fatal(err)
+	}
+	host, _ := os.Hostname()
+	h := Holder{PID: dead.Process.Pid, Host: host}
+	if err := ioutil.WriteFile(localname, h.encode(), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(localname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewBackend(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = b.TryLock("k", h)
+	if he, ok := err.(*HeldError); !ok || !he.Stale {
+		t.Errorf("stale lock errored: %v\n", err)
+	}
+	if _, err := os.Stat(localname); err != nil {
+		t.Errorf("stale lock file was removed: %v\n", err)
+	}
+}
+
+func TestFlockWait(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+
+	l := testHold(t, "k", Options{})
+	start := time.Now()
+	testExpect(t, "waiting", "k", Options{WaitFor: 300 * time.Millisecond}, 20, "timed out after 300ms")
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("waited only %s\n", d)
+	}
+	waits, _ := filepath.Glob(filepath.Join(dir, WaitPrefix+"*"))
+	if len(waits) != 0 {
+		t.Errorf("wait files left after waiting: %v\n", waits)
+	}
+
+	// While waiting, the waiter is recorded, and it acquires the lock
+	// once it's released.
+	waitname := filepath.Join(dir, WaitPrefix+strconv.Itoa(os.Getpid())+"_k")
+	seen := make(chan bool, 1)
+	go func() {
+		defer l.Unlock()
+		for i := 0; i < 100; i++ {
+			if _, err := os.Stat(waitname); err == nil {
+				seen <- true
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		seen <- false
+	}()
+	testExpect(t, "waiting for release", "k", Options{Wait: true}, 0, "")
+	if !<-seen {
+		t.Errorf("waiter wasn't recorded in %s\n", waitname)
+	}
+	if _, err := os.Stat(waitname); !os.IsNotExist(err) {
+		t.Errorf("wait file left after acquiring: %v\n", err)
+	}
+}
+
+func TestFlockSlots(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	opts := Options{Slots: 2}
+
+	first := testHold(t, "k", opts)
+	second := testHold(t, "k", opts)
+	for _, name := range []string{LockPrefix + "k", LockPrefix + "k.slot2"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("slot not held: %v\n", err)
+		}
+	}
+	testExpect(t, "exhausted", "k", opts, 20, "all 2 slots locked")
+	testExpect(t, "exclusive with slots held", "k", Options{}, 20, "locked by pid")
+	testExpect(t, "more slots", "k", Options{Slots: 3}, 0, "")
+
+	// Releasing either slot frees it, and an exclusive holder
+	// excludes the first slot.
+	second.Unlock()
+	testExpect(t, "second slot freed", "k", opts, 0, "")
+	first.Unlock()
+	excl := testHold(t, "k", Options{})
+	second = testHold(t, "k", opts)
+	testExpect(t, "exhausted with exclusive holder", "k", opts, 20, "all 2 slots locked")
+	second.Unlock()
+	excl.Unlock()
+}
+
+func TestFlockShared(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	localname := filepath.Join(dir, LockPrefix+"k")
+	shared := Options{Shared: true}
+
+	first := testHold(t, "k", shared)
+	second := testHold(t, "k", shared)
+	holders, err := ReadHolders(localname)
+	if err != nil || len(holders) != 2 || !holders[0].Shared || !holders[1].Shared {
+		t.Errorf("shared lock records %+v (error %v)\n", holders, err)
+	}
+	testExpect(t, "shared alongside shared", "k", shared, 0, "")
+	testExpect(t, "exclusive alongside shared", "k", Options{}, 20, "locked shared by")
+
+	// The lock file lasts as long as any shared holder.
+	first.Unlock()
+	if holders, err := ReadHolders(localname); err != nil || len(holders) != 1 {
+		t.Errorf("after one unlock, shared lock records %+v (error %v)\n", holders, err)
+	}
+	testExpect(t, "exclusive alongside remaining shared", "k", Options{}, 20, "locked shared by")
+	second.Unlock()
+	if _, err := os.Stat(localname); !os.IsNotExist(err) {
+		t.Errorf("lock file left after last shared unlock: %v\n", err)
+	}
+
+	excl := testHold(t, "k", Options{})
+	testExpect(t, "shared alongside exclusive", "k", shared, 20, "locked by")
+	excl.Unlock()
+}
+
+func TestFlockPath(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	path := filepath.Join(dir, "resource")
+	opts := Options{Path: path}
+	key, err := PathKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Locking a path excludes other keys locking it, and anyone
+	// locking it with flock(2) directly.
+	l := testHold(t, key, opts)
+	testExpect(t, "same path", "other", opts, 20, "locked by pid")
+	l.Unlock()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("locked path removed: %v\n", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	testExpect(t, "path locked by flock", "k", opts, 20, "locked by unknown holder")
+	testExpect(t, "shared path locked by flock", "k", Options{Path: path, Shared: true}, 20, "locked by unknown holder")
+	f.Close()
+	if _, err := os.Stat(filepath.Join(dir, LockPrefix+key)); !os.IsNotExist(err) {
+		t.Errorf("lock file left for path locked by flock: %v\n", err)
+	}
+
+	// The command is passed the locked path, and holds the lock as
+	// long as it, or a process it starts, keeps it open.
+	opts.PassFD = true
+	check := `[ "$` + FDEnv + `" = 3 ] && : <&3`
+	if perr := testRun("k", opts, "sh", "-c", check); perr != nil {
+		t.Errorf("passed lock: %+v\n", perr)
+	}
+	if perr := testRun("k", opts, "sh", "-c", check+` && { sleep 1 & }`); perr != nil {
+		t.Errorf("passed lock: %+v\n", perr)
+	}
+	testExpect(t, "path held by command", "k", Options{Path: path}, 20, "locked by")
+	time.Sleep(1500 * time.Millisecond)
+	testExpect(t, "path released by command", "k", Options{Path: path}, 0, "")
+}
+
+func TestPassFDBackend(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+
+	// Only a lock held through the flock backend can be passed, even
+	// if the options weren't checked.
+	opts := Options{Backend: "lease:" + dir, PassFD: true}
+	if opts.Check() == nil {
+		t.Errorf("passfd without path checked\n")
+	}
+	testExpect(t, "passfd with lease", "k", opts, 20, "passfd requires the flock backend")
+}
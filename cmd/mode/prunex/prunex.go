@@ -0,0 +1,384 @@
+// chris 082815
+
+// Package prunex implements the prunex mode, which runs a command
+// exclusively.
+//
+// See chrispennello.com/go/prun/cmd/prunex for its documentation.
+package prunex
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"path/filepath"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+const name = "prunex"
+
+// LockPrefix prefixes the names of command-specific lock files, which
+// are followed by the key.
+const LockPrefix = name + "_local_"
+
+// WaitPrefix prefixes the names of the files recording each process
+// waiting for a lock, which are followed by the process ID, an
+// underscore, and the key.
+const WaitPrefix = name + "_wait_"
+
+// Mode is the prunex mode.
+var Mode = &cmd.Mode{
+	Name:     name,
+	Synopsis: "Run a command exclusively (Unix only).",
+	Codes: []cmd.Code{
+		{Code: 20, Msg: "Could not acquire lock."},
+		{Code: 21, Msg: "Lost the lock while running the command, and stopped it."},
+	},
+	Flags: flags,
+	Main:  run,
+}
+
+// Options are the options that prunex applies to the lock.
+type Options struct {
+	// Whether to wait for the lock rather than failing to acquire
+	// it, and, if WaitFor isn't zero, for how long at most.  If
+	// WaitFor isn't zero, Wait is implied.
+	Wait    bool
+	WaitFor time.Duration
+
+	// How many holders may hold the lock at once, each in its own
+	// slot.  If zero, just one, as if it were one.
+	Slots int
+
+	// Whether to share the lock with any others who also share it,
+	// rather than holding it exclusively.  A shared lock excludes
+	// only exclusive holders, and may not have several slots.
+	Shared bool
+
+	// The file or directory to lock, if not empty, rather than a
+	// lock file named by the key.  It may not have several slots.
+	Path string
+
+	// Whether to pass the locked file or directory at Path to the
+	// command, which then holds the lock as long as it keeps it
+	// open.  See FDEnv.
+	PassFD bool
+
+	// The backend through which to lock, as for NewBackend.
+	Backend string
+	// How long a lease lasts unless renewed, for the lease backend.
+	// If zero, DefaultLease.
+	Lease time.Duration
+}
+
+// FDEnv names the environment variable that gives the number of the
+// file descriptor of the lock passed to the command.
+const FDEnv = "PRUNEX_FD"
+
+// Flags defines the options in cmd.Flags.
+func (o *Options) Flags() {
+	cmd.Flags.BoolVar(&o.Wait, "wait", false, "wait for the lock rather than failing")
+	cmd.Flags.DurationVar(&o.WaitFor, "waitfor", 0, "wait for the lock at most `duration`")
+	cmd.Flags.IntVar(&o.Slots, "slots", 1, "allow up to `n` holders of the lock at once")
+	cmd.Flags.BoolVar(&o.Shared, "shared", false, "share the lock with other shared holders")
+	cmd.Flags.StringVar(&o.Path, "path", "", "lock the file or directory at `path`")
+	cmd.Flags.BoolVar(&o.PassFD, "passfd", false, "pass the locked path to the command")
+	cmd.Flags.StringVar(&o.Backend, "backend", "flock", "lock through `backend`: flock, lease:dir, or tcp:address")
+	cmd.Flags.DurationVar(&o.Lease, "lease", DefaultLease, "renew a lease within `duration`, for the lease backend")
+}
+
+// Check returns an error if any of the options are invalid.
+func (o *Options) Check() error {
+	if o.WaitFor < 0 {
+		return errors.New("waitfor must be non-negative")
+	}
+	if o.Slots < 0 {
+		return errors.New("slots must be non-negative")
+	}
+	if o.Shared && o.Slots > 1 {
+		return errors.New("shared locks may not have several slots")
+	}
+	if o.Path != "" && o.Slots > 1 {
+		return errors.New("locks on a path may not have several slots")
+	}
+	if o.PassFD && o.Path == "" {
+		return errors.New("passfd requires path")
+	}
+	if o.Lease < 0 {
+		return errors.New("lease must be non-negative")
+	}
+	kind, _, err := splitBackend(o.Backend)
+	if err != nil {
+		return err
+	}
+	if kind != "flock" && (o.Shared || o.Path != "") {
+		return errors.New("shared and path require the flock backend")
+	}
+	return nil
+}
+
+// PathKey returns the key naming the lock file that records the
+// holders of the lock on the file or directory at path.
+func PathKey(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return cmd.MakeKey("path", []string{abs}), nil
+}
+
+// waits reports whether to wait for the lock.
+func (o *Options) waits() bool {
+	return o.Wait || o.WaitFor > 0
+}
+
+var state struct {
+	opts Options
+}
+
+// flags defines the options.
+func flags() {
+	state.opts.Flags()
+	cmd.StateDirVar()
+	cmd.KeyVar()
+}
+
+// slotPrefix separates the key from the slot number in the names of
+// lock files with several slots.
+const slotPrefix = ".slot"
+
+// SlotKey returns what follows LockPrefix in the name of the lock file
+// for the given slot, numbered from 1, of the lock named by key.  Slot 1
+// is named by the key alone, as is the only slot of a lock with just
+// one, so that locks on the same key exclude each other in their first
+// slot whatever their number of slots.
+func SlotKey(key string, slot int) string {
+	if slot <= 1 {
+		return key
+	}
+	return fmt.Sprintf("%s%s%d", key, slotPrefix, slot)
+}
+
+// ParseSlotKey parses what follows LockPrefix in the name of a lock
+// file, returning the key and the slot, as for SlotKey, or 0 if the file
+// is named by the key alone.
+func ParseSlotKey(s string) (key string, slot int) {
+	i := strings.LastIndex(s, slotPrefix)
+	if i < 0 {
+		return s, 0
+	}
+	slot, err := strconv.Atoi(s[i+len(slotPrefix):])
+	if err != nil || slot < 2 {
+		return s, 0
+	}
+	return s[:i], slot
+}
+
+// slotKeys returns the keys naming each of the slots of the lock named
+// by key.
+func slotKeys(key string, slots int) []string {
+	if slots <= 1 {
+		return []string{key}
+	}
+	keys := make([]string, slots)
+	for i := range keys {
+		keys[i] = SlotKey(key, i+1)
+	}
+	return keys
+}
+
+// HeldError is returned by a Backend when others hold a lock,
+// describing them.
+type HeldError struct {
+	// The holders of the lock: just one, unless they share it.
+	Holders []Holder
+	// Whether all the holders are known to no longer exist.
+	Stale bool
+}
+
+// String describes the holders, such as
+//
+//	by pid 4242 on web1 since 2026-10-18 06:00:01 UTC: sh sync.sh
+func (e *HeldError) String() string {
+	f := make([]string, len(e.Holders))
+	for i, h := range e.Holders {
+		f[i] = h.String()
+	}
+	s := "by " + strings.Join(f, "; ")
+	if len(e.Holders) > 0 && e.Holders[0].Shared {
+		s = "shared " + s
+	}
+	switch {
+	case !e.Stale:
+	case len(e.Holders) == 1:
+		s += ", which no longer exists"
+	default:
+		s += ", which no longer exist"
+	}
+	return s
+}
+
+func (e *HeldError) Error() string {
+	return "locked " + e.String()
+}
+
+// lockedError is returned by acquire when others hold all the slots of
+// the lock.
+type lockedError []*HeldError
+
+func (e lockedError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	f := make([]string, len(e))
+	for i, h := range e {
+		f[i] = h.String()
+	}
+	return fmt.Sprintf("all %d slots locked, %s", len(e), strings.Join(f, "; "))
+}
+
+// acquire acquires the first free slot of the lock through the backend
+// without waiting, trying each of the slots named by keys in turn.  If
+// others hold all the slots, the error is a lockedError describing them.
+func acquire(b Backend, keys []string, h Holder) (Lock, error) {
+	var e lockedError
+	for _, key := range keys {
+		h.Start = time.Now()
+		l, err := b.TryLock(key, h)
+		if err == nil {
+			return l, nil
+		}
+		he, ok := err.(*HeldError)
+		if !ok {
+			return nil, err
+		}
+		e = append(e, he)
+	}
+	return nil, e
+}
+
+// Layer returns a cmd.Layer that runs the command exclusively, holding
+// the lock named by the given key through the backend given by opts.
+// If opts.Slots is more than one, up to that many may hold the lock at
+// once, or, if opts.Shared is set, any others sharing it.  If opts.Path
+// is set, the file or directory there is locked instead, and the key is
+// that given by PathKey.  The backend records the Holder of the lock.
+//
+// If opts say so, it waits for the lock, unless the Proc's context is
+// done or, for the prun utilities, the parent process receives one of
+// the Proc's Relay signals.  If the lock cannot be acquired, it fails
+// with exit code 20, describing its holders.  It also fails with exit
+// code 20 if opts.PassFD is set but the lock isn't held through the
+// flock backend, as Options.Check would have reported.
+//
+// If the lock is lost while the command runs, as when its lease is taken
+// over, the command is stopped just as if the Proc's context were done,
+// and it fails with exit code 21.
+func Layer(key string, opts Options) cmd.Layer {
+	return func(next cmd.Runner) cmd.Runner {
+		return func(proc *cmd.Proc) *cmd.ProcError {
+			dir, err := cmd.StateDir()
+			if err != nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 20}
+			}
+			key := key
+			if opts.Path != "" {
+				if key, err = PathKey(opts.Path); err != nil {
+					return &cmd.ProcError{Msg: err.Error(), Code: 20}
+				}
+			}
+			b, err := NewBackend(opts)
+			if err != nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 20}
+			}
+			keys := slotKeys(key, opts.Slots)
+			h := newHolder(proc)
+			h.Shared = opts.Shared
+			var l Lock
+			if opts.waits() {
+				waitname := filepath.Join(dir, fmt.Sprintf("%s%d_%s", WaitPrefix, h.PID, key))
+				l, err = wait(proc, b, keys, waitname, h, opts)
+			} else {
+				l, err = acquire(b, keys, h)
+			}
+			if err != nil {
+				return &cmd.ProcError{Msg: err.Error(), Code: 20}
+			}
+			defer l.Unlock()
+			if opts.PassFD {
+				fl, ok := l.(*lock)
+				if !ok {
+					return &cmd.ProcError{Msg: "passfd requires the flock backend", Code: 20}
+				}
+				passFD(proc, fl.file)
+			}
+			lost := l.Lost()
+			if lost == nil {
+				return next(proc)
+			}
+			return runLosable(proc, next, lost)
+		}
+	}
+}
+
+// runLosable runs the command through next while holding a lock that
+// might be lost, stopping the command if it is.  A lock lost once the
+// command has finished doesn't matter.
+func runLosable(proc *cmd.Proc, next cmd.Runner, lost <-chan error) *cmd.ProcError {
+	cancel := proc.WithCancel()
+	defer cancel()
+	var run struct {
+		sync.Mutex
+		finished bool
+		why      error
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case err := <-lost:
+			run.Lock()
+			if !run.finished {
+				run.why = err
+				cancel()
+			}
+			run.Unlock()
+		case <-finished:
+		}
+	}()
+	perr := next(proc)
+	run.Lock()
+	run.finished = true
+	err := run.why
+	run.Unlock()
+	if err != nil {
+		return &cmd.ProcError{
+			Msg:  fmt.Sprintf("lost lock, %v: %s\n", err, proc),
+			Code: 21,
+		}
+	}
+	return perr
+}
+
+// passFD passes the file f to the command, naming its file descriptor
+// in the FDEnv environment variable.
+func passFD(proc *cmd.Proc, f *os.File) {
+	fd := 3 + len(proc.ExtraFiles)
+	proc.ExtraFiles = append(proc.ExtraFiles, f)
+	if proc.Env == nil {
+		proc.Env = os.Environ()
+	}
+	proc.Env = append(proc.Env, fmt.Sprintf("%s=%d", FDEnv, fd))
+}
+
+func run(s cmd.State) {
+	if err := state.opts.Check(); err != nil {
+		cmd.BadArgs(err.Error())
+	}
+	s.Run(Layer(s.Key(), state.opts))
+}
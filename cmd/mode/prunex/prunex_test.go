@@ -0,0 +1,21 @@
+// chris 2026-10-18
+
+package prunex
+
+import (
+	"testing"
+)
+
+func TestSlotKey(t *testing.T) {
+	for _, key := range []string{"backup", "sh_c_true.v2.3f94a0485d33f11f", "x.slot", "x.slotz"} {
+		for _, slot := range []int{0, 2, 12} {
+			k, s := ParseSlotKey(SlotKey(key, slot))
+			if k != key || s != slot {
+				t.Errorf("ParseSlotKey(SlotKey(%q, %d)) = %q, %d\n", key, slot, k, s)
+			}
+		}
+		if k := SlotKey(key, 1); k != key {
+			t.Errorf("SlotKey(%q, 1) = %q\n", key, k)
+		}
+	}
+}
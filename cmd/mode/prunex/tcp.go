@@ -0,0 +1,219 @@
+// chris 2026-10-18 Locks served over TCP.
+
+package prunex
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// The protocol between tcpBackend and Server is line-oriented.  The
+// client sends
+//
+//	lock key
+//
+// followed by the holder, as recorded in a lock file, and a blank line.
+// The server replies "ok" if the client now holds the lock, "held"
+// followed by the holder and a blank line if someone else does, or
+// "error" followed by a message.  The client holds the lock until it
+// disconnects or sends anything more.
+
+// handshakeTimeout limits how long a client or server waits for the
+// other while acquiring a lock.
+const handshakeTimeout = 10 * time.Second
+
+// readRecord reads the lines up to a blank line.
+func readRecord(r *bufio.Reader) ([]byte, error) {
+	var b bytes.Buffer
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			return b.Bytes(), nil
+		}
+		b.Write(line)
+	}
+}
+
+// tcpBackend locks by means of a Server listening at a TCP address.
+type tcpBackend struct {
+	addr string
+}
+
+// TryLock asks the server for the lock named by the key on behalf of
+// the holder.
+func (b *tcpBackend) TryLock(key string, h Holder) (Lock, error) {
+	conn, err := net.DialTimeout("tcp", b.addr, handshakeTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+	reply, err := request(conn, r, key, h)
+	if reply != "ok" {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	l := &tcpLock{conn: conn, done: make(chan struct{}), lost: make(chan error, 1)}
+	go l.watch(r)
+	return l, nil
+}
+
+// request requests the lock over the connection, returning the reply,
+// and, unless it's "ok", an error.
+func request(conn net.Conn, r *bufio.Reader, key string, h Holder) (string, error) {
+	if _, err := fmt.Fprintf(conn, "lock %s\n%s\n", key, h.encode()); err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply := strings.TrimSpace(line)
+	switch {
+	case reply == "ok":
+		return reply, nil
+	case reply == "held":
+		rec, err := readRecord(r)
+		if err != nil {
+			return "", err
+		}
+		return reply, &HeldError{Holders: []Holder{parseHolder(rec)}}
+	case strings.HasPrefix(reply, "error "):
+		return "", fmt.Errorf("lock server: %s", strings.TrimPrefix(reply, "error "))
+	}
+	return "", fmt.Errorf("lock server: bad reply: %q", reply)
+}
+
+// tcpLock is a lock held through a Server for as long as its connection
+// stays open.
+type tcpLock struct {
+	conn net.Conn
+	// done is closed when the lock is released.
+	done chan struct{}
+	// lost receives why the lock was lost, if it was.
+	lost chan error
+}
+
+// watch reports the lock lost if the server goes away before the lock
+// is released.
+func (l *tcpLock) watch(r *bufio.Reader) {
+	_, err := r.ReadByte()
+	select {
+	case <-l.done:
+	default:
+		l.lost <- fmt.Errorf("connection to lock server %s: %v", l.conn.RemoteAddr(), err)
+	}
+}
+
+// Lost returns a channel that receives an error once the connection to
+// the server is lost, and with it the lock.
+func (l *tcpLock) Lost() <-chan error {
+	return l.lost
+}
+
+// Unlock releases the lock by disconnecting.
+func (l *tcpLock) Unlock() error {
+	close(l.done)
+	return l.conn.Close()
+}
+
+// Server serves locks to the tcp backend, holding each on behalf of the
+// client that acquired it for as long as the client stays connected.
+// Since TCP keepalives are enabled, clients on hosts that go away are
+// eventually disconnected.
+type Server struct {
+	mu      sync.Mutex
+	holders map[string]Holder
+}
+
+// NewServer returns a new Server with no locks held.
+func NewServer() *Server {
+	return &Server{holders: make(map[string]Holder)}
+}
+
+// Serve accepts connections from clients on the listener, serving each
+// in its own goroutine, until accepting fails.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve serves a single client.
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	r := bufio.NewReader(conn)
+	key, h, err := readRequest(r)
+	if err != nil {
+		fmt.Fprintf(conn, "error %s\n", err)
+		return
+	}
+	if other, ok := s.lock(key, h); !ok {
+		fmt.Fprintf(conn, "held\n%s\n", other.encode())
+		return
+	}
+	defer s.unlock(key)
+	if _, err := fmt.Fprintf(conn, "ok\n"); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	r.ReadByte()
+}
+
+// readRequest reads a client's request for a lock.
+func readRequest(r *bufio.Reader) (string, Holder, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", Holder{}, err
+	}
+	f := strings.Fields(line)
+	if len(f) != 2 || f[0] != "lock" {
+		return "", Holder{}, fmt.Errorf("bad request: %q", strings.TrimSpace(line))
+	}
+	// Slots are named by a key followed by a slot suffix, which may
+	// take them beyond MaxKeyLength, so only the key is checked.
+	key, _ := ParseSlotKey(f[1])
+	if err := cmd.CheckKey(key); err != nil {
+		return "", Holder{}, err
+	}
+	rec, err := readRecord(r)
+	if err != nil {
+		return "", Holder{}, err
+	}
+	return f[1], parseHolder(rec), nil
+}
+
+// lock acquires the lock named by key for the holder, unless someone
+// else holds it, in which case it returns them.
+func (s *Server) lock(key string, h Holder) (Holder, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if other, ok := s.holders[key]; ok {
+		return other, false
+	}
+	s.holders[key] = h
+	return h, true
+}
+
+// unlock releases the lock named by key.
+func (s *Server) unlock(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.holders, key)
+}
@@ -0,0 +1,65 @@
+// chris 2026-10-18 Waiting for locks.
+
+package prunex
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"io/ioutil"
+	"os/signal"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// pollInterval is how often a waiting prunex tries the lock again.
+// Polling, rather than blocking, keeps the wait interruptible.
+const pollInterval = 100 * time.Millisecond
+
+// wait acquires the lock as acquire does, but waits for it as long as
+// opts allow, recording the waiting holder in the file waitname in the
+// meantime.
+func wait(proc *cmd.Proc, b Backend, keys []string, waitname string, h Holder, opts Options) (Lock, error) {
+	l, err := acquire(b, keys, h)
+	if _, ok := err.(lockedError); !ok {
+		return l, err
+	}
+
+	h.Start = time.Now()
+	if err := ioutil.WriteFile(waitname, h.encode(), 0666); err != nil {
+		return nil, err
+	}
+	defer os.Remove(waitname)
+
+	var sigc chan os.Signal
+	if len(proc.Relay) > 0 {
+		sigc = make(chan os.Signal, 1)
+		signal.Notify(sigc, proc.Relay...)
+		defer signal.Stop(sigc)
+	}
+	var timeout <-chan time.Time
+	if opts.WaitFor > 0 {
+		t := time.NewTimer(opts.WaitFor)
+		defer t.Stop()
+		timeout = t.C
+	}
+	tick := time.NewTicker(pollInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+		case <-timeout:
+			return nil, fmt.Errorf("timed out after %s: %s", opts.WaitFor, err)
+		case sig := <-sigc:
+			return nil, fmt.Errorf("interrupted by %s while waiting: %s", sig, err)
+		case <-proc.Context().Done():
+			return nil, proc.Context().Err()
+		}
+		l, err = acquire(b, keys, h)
+		if _, ok := err.(lockedError); !ok {
+			return l, err
+		}
+	}
+}
@@ -1,6 +1,6 @@
 // chris 090515
 
-package main
+package prunfail
 
 import (
 	"errors"
@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"io/ioutil"
+
+	"chrispennello.com/go/prun/cmd"
 )
 
 const maxLogSize = 16384
@@ -45,6 +47,24 @@ func newLogFile(path string) (*logFile, error) {
 	return lf, nil
 }
 
+// ReadFailures returns the consecutive failure count recorded in the
+// log file at path, and when the file was last written.
+func ReadFailures(path string) (failures int, last time.Time, err error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	lf := &logFile{path: path, data: string(data)}
+	if err := lf.parse(); err != nil {
+		return 0, time.Time{}, err
+	}
+	return lf.failures, fi.ModTime(), nil
+}
+
 func (lf *logFile) parse() error {
 	const footerprefix = "fail "
 
@@ -73,7 +93,9 @@ func (lf *logFile) parse() error {
 	return nil
 }
 
-func (lf *logFile) write(data []byte) error {
+// write writes the output data, followed by a summary of the command's
+// Result, if it was waited on, and the footer.
+func (lf *logFile) write(data []byte, r *cmd.Result) error {
 	flag := os.O_WRONLY | os.O_TRUNC | os.O_CREATE
 	file, err := os.OpenFile(lf.path, flag, 0666)
 	if err != nil {
@@ -84,6 +106,9 @@ func (lf *logFile) write(data []byte) error {
 	if len(newdata) > maxLogSize {
 		newdata = newdata[len(newdata)-maxLogSize:]
 	}
+	if r != nil {
+		newdata += fmt.Sprintf("%s result %s\n", r.End, r)
+	}
 	newdata += fmt.Sprintf("%s fail %d\n", time.Now(), lf.failures)
 	_, err2 := io.WriteString(file, newdata)
 	return err2
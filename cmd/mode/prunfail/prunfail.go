@@ -0,0 +1,154 @@
+// chris 090415
+
+// Package prunfail implements the prunfail mode, which guards the
+// output of a potentially or intermittently failing command.
+//
+// See chrispennello.com/go/prun/cmd/prunfail for its documentation.
+package prunfail
+
+import (
+	"io"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/util/ringbuffer"
+)
+
+const name = "prunfail"
+
+// LogPrefix and LogSuffix surround the key in the names of log files.
+const (
+	LogPrefix = name + "_"
+	LogSuffix = ".log"
+)
+
+// Mode is the prunfail mode.
+var Mode = &cmd.Mode{
+	Name:     name,
+	Synopsis: "Guard the output of a potentially or intermittently failing command.",
+	Args:     []string{"maxfail"},
+	Codes: []cmd.Code{
+		{Code: 31, Msg: "Error opening, creating, or writing to log file."},
+		{Code: 32, Msg: "Error copying consolidated output to standard error."},
+	},
+	Flags: flags,
+	Main:  run,
+}
+
+var state struct {
+	cmd cmd.State
+
+	// The maximum number of consecutive failures, after which the
+	// output of the most recent failure will be emitted, and the
+	// failure count will be reset.
+	maxfail int
+}
+
+// flags defines the options.
+func flags() {
+	cmd.StateDirVar()
+	cmd.KeyVar()
+}
+
+// setup initializes the state given the parsed command line.
+func setup(s cmd.State) {
+	state.cmd = s
+
+	state.maxfail = int(state.cmd.Uint(0, 1))
+}
+
+func write(lf *logFile, r *cmd.Result, rbuf *ringbuffer.B, stderr io.Writer) *cmd.ProcError {
+	data := rbuf.Bytes()
+	if err := lf.write(data, r); err != nil {
+		return &cmd.ProcError{Msg: err.Error(), Code: 31}
+	}
+
+	if stderr != nil {
+		_, err := stderr.Write(data)
+		if err != nil {
+			return &cmd.ProcError{Msg: err.Error(), Code: 32}
+		}
+	}
+	return nil
+}
+
+func fail(lf *logFile, perr *cmd.ProcError, r *cmd.Result, rbuf *ringbuffer.B, stderr io.Writer, maxfail int) *cmd.ProcError {
+	lf.failures++
+	if perr.Msg != "" {
+		rbuf.Write([]byte(perr.Msg + "\n"))
+	}
+	if lf.failures <= maxfail {
+		stderr = nil
+	}
+	if werr := write(lf, r, rbuf, stderr); werr != nil {
+		return werr
+	}
+	// Already written along with the rest of the output, if at
+	// all.
+	return &cmd.ProcError{Code: perr.Code}
+}
+
+// started reports whether the command has been started.
+func started(proc *cmd.Proc) bool {
+	select {
+	case <-proc.Started():
+		return true
+	default:
+		return false
+	}
+}
+
+// Layer returns a cmd.Layer that guards the command's output, tracking
+// failures in the log file in the state directory named by the given
+// key.  The combined output is only written to the command's standard
+// error, as it was before the Layer, if the command succeeds or has
+// failed more than maxfail consecutive times.  A summary of each run's
+// cmd.Result, including its resource usage, is also written to the log
+// file.  If there's an error with the log file, it fails with exit code
+// 31, and if there's an error writing to standard error, with exit code
+// 32.
+func Layer(key string, maxfail int) cmd.Layer {
+	return func(next cmd.Runner) cmd.Runner {
+		return func(proc *cmd.Proc) *cmd.ProcError {
+			// Copy combined output from process into ring
+			// buffer.  So in the end, if a lot is written,
+			// we'll only be left with the last bits.
+			rbuf := ringbuffer.New(maxLogSize)
+			stderr := proc.Cmd.Stderr
+			proc.Cmd.Stdout = rbuf
+			proc.Cmd.Stderr = rbuf
+
+			logname, lferr := proc.KeyPath(LogPrefix, key, LogSuffix)
+			if lferr != nil {
+				return &cmd.ProcError{Msg: lferr.Error(), Code: 31}
+			}
+			lf, lferr := newLogFile(logname)
+			if lferr != nil {
+				return &cmd.ProcError{Msg: lferr.Error(), Code: 31}
+			}
+
+			if perr := next(proc); perr != nil {
+				if !started(proc) {
+					// There aren't any errors that
+					// could be caused by just trying
+					// to start the process that we
+					// should elide.
+					return perr
+				}
+				// These are the errors that we'll want
+				// to potentially elide.
+				return fail(lf, perr, proc.Result(), rbuf, stderr, maxfail)
+			}
+
+			// Success: reset the failure count and not only
+			// do we log, but the consolidated output also goes
+			// unconditionally to standard error.
+			lf.failures = 0
+			return write(lf, proc.Result(), rbuf, stderr)
+		}
+	}
+}
+
+func run(s cmd.State) {
+	setup(s)
+	s.Run(Layer(s.Key(), state.maxfail))
+}
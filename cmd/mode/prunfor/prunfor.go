@@ -0,0 +1,242 @@
+// chris 082815
+
+// Package prunfor implements the prunfor mode, which runs a command
+// for an optionally limited amount of time.
+//
+// See chrispennello.com/go/prun/cmd/prunfor for its documentation.
+package prunfor
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// Mode is the prunfor mode.
+var Mode = &cmd.Mode{
+	Name:     "prunfor",
+	Synopsis: "Run a command for an optionally limited amount of time.",
+	Args:     []string{"timelimit"},
+	Codes: []cmd.Code{
+		{Code: 40, Msg: "Timed out, and the command was killed."},
+		{Code: 41, Msg: "Timed out, and the command exited after being sent the -signal signal."},
+		{Code: 42, Msg: "Idle, and the command was killed."},
+		{Code: 43, Msg: "Idle, and the command exited after being sent the -signal signal."},
+	},
+	Flags: flags,
+	Main:  run,
+}
+
+// Limits are the limits that prunfor applies to the command.
+type Limits struct {
+	// Time timelimit that the specified program can run for.  If
+	// zero, no time limit will be applied.
+	Timelimit time.Duration
+
+	// Signal to send the command when it times out, and how long to
+	// wait after that before killing it.  If Killafter is zero, the
+	// command is killed immediately.  Check defaults Signal to
+	// SIGTERM.
+	Signal    os.Signal
+	Killafter time.Duration
+
+	// How long the command may go without producing any output,
+	// counting from when it starts.  If zero, no idle limit will be
+	// applied.  Only output written to the Proc's Stdout and Stderr
+	// is seen, so at least one of them must be set.
+	Idle time.Duration
+
+	// Whether to run the command in its own process group.
+	Group bool
+	// Whether to kill and reap orphaned descendants as a subreaper
+	// once the command times out or goes idle.  This marks the whole
+	// calling process as a subreaper for good, and reaping kills and
+	// waits on every child of the process that no Proc started, as
+	// described for cmd.Reap, so only set it in a process that starts
+	// commands through Procs alone, such as prunfor.
+	Subreaper bool
+}
+
+// Flags defines the options for all the limits other than Timelimit in
+// cmd.Flags.
+func (l *Limits) Flags() {
+	cmd.Flags.BoolVar(&l.Group, "group", false, "run the command in its own process group")
+	cmd.Flags.BoolVar(&l.Subreaper, "subreaper", false, "kill and reap orphaned descendants (Linux only)")
+	cmd.SignalVar(&l.Signal, "signal", syscall.SIGTERM, "`signal` to send on timeout before killing")
+	cmd.Flags.DurationVar(&l.Killafter, "killafter", 0, "how long after the signal to kill")
+	cmd.Flags.DurationVar(&l.Idle, "idle", 0, "how long the command may produce no output")
+}
+
+// Check returns an error if any of the limits are invalid, defaulting
+// any that are unset.
+func (l *Limits) Check() error {
+	if l.Timelimit < 0 {
+		return errors.New("timelimit must be non-negative")
+	}
+	if l.Killafter < 0 {
+		return errors.New("killafter must be non-negative")
+	}
+	if l.Idle < 0 {
+		return errors.New("idle must be non-negative")
+	}
+	if l.Signal == nil {
+		l.Signal = syscall.SIGTERM
+	}
+	return nil
+}
+
+var state struct {
+	cmd cmd.State
+
+	limits Limits
+}
+
+// flags defines the options.
+func flags() {
+	state.limits.Flags()
+}
+
+// setup initializes the state given the parsed command line.
+func setup(s cmd.State) {
+	state.cmd = s
+
+	state.limits.Timelimit = state.cmd.Duration(0)
+	if err := state.limits.Check(); err != nil {
+		cmd.BadArgs(err.Error())
+	}
+}
+
+// stop stops the process once it has timed out or gone idle and waits
+// for it to exit.  It reports whether the process exited after being
+// sent the soft timeout signal, rather than having to be killed, and
+// any error signaling it.  If the soft timeout signal can't be sent,
+// the process is killed right away.
+func stop(proc *cmd.Proc, done chan *cmd.ProcError, l Limits) (bool, error) {
+	var err error
+	if l.Killafter > 0 {
+		if l.Signal == nil {
+			err = errors.New("no signal to send")
+		} else {
+			err = proc.Signal(l.Signal)
+		}
+		if err == nil {
+			select {
+			case <-done:
+				// Don't care if this errors.
+				return true, nil
+			case <-time.After(l.Killafter):
+			}
+		}
+	}
+	if kerr := proc.Kill(); kerr != nil && err == nil {
+		err = kerr
+	}
+	<-done // Don't care if this errors.
+	return false, err
+}
+
+// Layer returns a cmd.Layer that runs the command within the given
+// limits.  If the command times out, it fails with exit code 40, or 41
+// if the command exited after being sent the soft timeout signal.  If
+// the command goes idle, it fails with exit code 42, or 43 if the
+// command exited after being sent the soft timeout signal.
+func Layer(l Limits) cmd.Layer {
+	return func(next cmd.Runner) cmd.Runner {
+		return func(proc *cmd.Proc) *cmd.ProcError {
+			if l.Subreaper {
+				if err := cmd.Subreaper(); err != nil {
+					return &cmd.ProcError{Msg: err.Error(), Code: 1}
+				}
+			}
+			if l.Group {
+				proc.Group = true
+			}
+
+			// Only track output activity if necessary;
+			// otherwise, the command may write directly to
+			// our standard output and error.
+			var activity *cmd.Activity
+			if l.Idle > 0 {
+				if proc.Cmd.Stdout == nil && proc.Cmd.Stderr == nil {
+					return &cmd.ProcError{Msg: "idle limit requires the command's output to be seen", Code: 1}
+				}
+				activity = cmd.NewActivity()
+				if proc.Cmd.Stdout != nil {
+					proc.Cmd.Stdout = activity.Writer(proc.Cmd.Stdout)
+				}
+				if proc.Cmd.Stderr != nil {
+					proc.Cmd.Stderr = activity.Writer(proc.Cmd.Stderr)
+				}
+			}
+
+			// Only wait on the process here, so that a timeout
+			// doesn't race with the process exiting on its own.
+			done := make(chan *cmd.ProcError, 1)
+			go func() {
+				done <- next(proc)
+			}()
+
+			// Don't start timing until the command has
+			// started, if it ever does.
+			select {
+			case perr := <-done:
+				return perr
+			case <-proc.Started():
+			}
+
+			// Nil channels block forever: no time limit, or no
+			// idle limit.
+			var timeout <-chan time.Time
+			if l.Timelimit > 0 {
+				timeout = time.After(l.Timelimit)
+			}
+			var idle <-chan struct{}
+			if activity != nil {
+				activity.Reset()
+				stopIdle := make(chan struct{})
+				defer close(stopIdle)
+				idle = activity.Idle(l.Idle, stopIdle)
+			}
+
+			var perr *cmd.ProcError
+			select {
+			case perr := <-done:
+				return perr
+			case <-timeout:
+				perr = &cmd.ProcError{
+					Msg:  fmt.Sprintf("timed out: %s\n", proc),
+					Code: 40,
+				}
+			case <-idle:
+				perr = &cmd.ProcError{
+					Msg:  fmt.Sprintf("idle for %s: %s\n", l.Idle, proc),
+					Code: 42,
+				}
+			}
+			soft, err := stop(proc, done, l)
+			if soft {
+				perr.Code++
+			} else if l.Killafter > 0 {
+				perr.Msg += fmt.Sprintf("killed: %s\n", proc)
+			}
+			if err != nil {
+				perr.Msg += fmt.Sprintf("stopping: %v\n", err)
+			}
+			if l.Subreaper {
+				if err := cmd.Reap(); err != nil {
+					perr.Msg += fmt.Sprintf("reaping: %v\n", err)
+				}
+			}
+			return perr
+		}
+	}
+}
+
+func run(s cmd.State) {
+	setup(s)
+	s.Run(Layer(state.limits))
+}
@@ -0,0 +1,40 @@
+// chris 2026-10-18
+
+// Package prunlockd implements the prunlockd mode, which serves locks
+// to the tcp backend of prunex.
+//
+// See chrispennello.com/go/prun/cmd/prunlockd for its documentation.
+package prunlockd
+
+import (
+	"net"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+)
+
+// Mode is the prunlockd mode.
+var Mode = &cmd.Mode{
+	Name:     "prunlockd",
+	Synopsis: "Serve locks to prunex over TCP.",
+	Args:     []string{"address"},
+	Optional: true,
+	Codes: []cmd.Code{
+		{Code: 20, Msg: "Could not listen on or serve the address."},
+	},
+	Main: run,
+}
+
+func run(s cmd.State) {
+	ln, err := net.Listen("tcp", s.Me.Args[0])
+	if err != nil {
+		(&cmd.ProcError{Msg: err.Error(), Code: 20}).Exit()
+	}
+	srv := prunex.NewServer()
+	if s.Cmd.Name == "" {
+		err = srv.Serve(ln)
+		(&cmd.ProcError{Msg: err.Error(), Code: 20}).Exit()
+	}
+	go srv.Serve(ln)
+	s.Run()
+}
@@ -0,0 +1,195 @@
+// chris 2016-03-06
+
+// TODO Extend index template injection to the command itself?
+
+// Package prunparallel implements the prunparallel mode, which runs
+// commands in parallel.
+//
+// See chrispennello.com/go/prun/cmd/prunparallel for its documentation.
+package prunparallel
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// Mode is the prunparallel mode.
+var Mode = &cmd.Mode{
+	Name:     "prunparallel",
+	Synopsis: "Run commands in parallel.",
+	Args:     []string{"total", "concur", "indextemplate"},
+	Flags:    flags,
+	Main:     run,
+}
+
+var state struct {
+	cmd cmd.State
+
+	total  uint64
+	concur uint64
+
+	indextemplate string
+
+	// Whether to report each command's cmd.Result.
+	stats bool
+}
+
+// flags defines the options.
+func flags() {
+	cmd.Flags.BoolVar(&state.stats, "stats", false, "report how each command exited and the resources it used")
+}
+
+// setup initializes the state given the parsed command line.
+func setup(s cmd.State) {
+	state.cmd = s
+
+	state.total = state.cmd.Uint(0, 0)
+	state.concur = state.cmd.Uint(1, 1)
+	state.indextemplate = state.cmd.Me.Args[2]
+}
+
+// InjectIndex returns a copy of args, replacing occurrences of
+// indextemplate with the decimal string representation of the given
+// index.  If indextemplate is the empty string, no replacement occurs,
+// and args itself is returned.
+func InjectIndex(args []string, indextemplate string, index uint64) []string {
+	if len(indextemplate) == 0 {
+		return args
+	}
+	args2 := make([]string, len(args))
+	new := fmt.Sprintf("%d", index)
+	for i, arg := range args {
+		args2[i] = strings.Replace(arg, indextemplate, new, -1)
+	}
+	return args2
+}
+
+// NewInjectedProc returns a new cmd.Proc.  It first, however, runs
+// through args, replacing occurrences of indextemplate with the decimal
+// string representation of the given index, as with InjectIndex.
+func NewInjectedProc(command string, args []string, indextemplate string, index uint64) *cmd.Proc {
+	return cmd.NewProc(command, InjectIndex(args, indextemplate, index))
+}
+
+func worker(work chan *cmd.Proc, run cmd.Runner, perrs chan *cmd.ProcError, done chan struct{}) {
+	for proc := range work {
+		if pe := run(proc); pe != nil {
+			perrs <- pe
+		}
+	}
+	done <- struct{}{}
+}
+
+// Parallel runs total commands, at most concur at a time, by running
+// the cmd.Proc that newProc returns for each 0-based index through run.
+//
+// As soon as there is a non-successful termination of one of the
+// commands, Parallel ceases launching any new commands, waits for the
+// currently-running commands to terminate, and returns the
+// *cmd.ProcError of that first non-successful termination.  Otherwise,
+// it returns nil.  If concur is zero, it runs nothing and returns a
+// *cmd.ProcError with exit code 2.
+func Parallel(total, concur uint64, newProc func(index uint64) *cmd.Proc, run cmd.Runner) *cmd.ProcError {
+	// Without workers, the work would never be done.
+	if concur == 0 {
+		return &cmd.ProcError{Msg: "concur must be positive", Code: 2}
+	}
+	// Special-case trivial total since we won't launch any workers.
+	if total == 0 {
+		return nil
+	}
+
+	work := make(chan *cmd.Proc)
+	perrs := make(chan *cmd.ProcError)
+	done := make(chan struct{})
+	abort := make(chan struct{})
+
+	// Determine how many workers we'll need and start 'em all up.
+	// Note that total needs to be at least 1 here.  Otherwise,
+	// there will be no workers to signal that the work is done!
+	// See the special case at the beginning of this function.
+	var workers uint64
+	if concur > total {
+		workers = total
+	} else {
+		workers = concur
+	}
+	for i := uint64(0); i < workers; i++ {
+		go worker(work, run, perrs, done)
+	}
+
+	// Simple work scheduler: create cmd.Proc objects based off of
+	// indices and feed them into the workers.  Bug out on abort.
+	go func() {
+	schedloop:
+		for i := uint64(0); i < total; i++ {
+			select {
+			case <-abort:
+				break schedloop
+			default:
+				// No abort, proceed as usual.
+			}
+			work <- newProc(i)
+		}
+		close(work)
+	}()
+
+	workersdone := uint64(0)
+	// Parallel returns the first error, if there is one.
+	var first *cmd.ProcError
+
+mainloop:
+	for {
+		select {
+		case pe := <-perrs:
+			if first == nil && pe.Code != 0 {
+				first = pe
+				close(abort)
+			}
+		case <-done:
+			workersdone++
+			if workersdone == workers {
+				// Just in case something goes wrong and
+				// someone tries to write to either of
+				// these, we'll panic.
+				close(perrs)
+				close(done)
+				break mainloop
+			}
+		}
+	}
+
+	return first
+}
+
+// printed runs the command, printing any error message right away
+// rather than leaving it to be printed on exit, and, if requested, a
+// summary of its cmd.Result.
+func printed(proc *cmd.Proc) *cmd.ProcError {
+	pe := cmd.Exec(proc)
+	if r := proc.Result(); state.stats && r != nil {
+		log.Printf("%s: %s\n", proc, r)
+	}
+	if pe == nil {
+		return nil
+	}
+	pe.Print()
+	return &cmd.ProcError{Code: pe.Code}
+}
+
+func run(s cmd.State) {
+	setup(s)
+	newProc := func(index uint64) *cmd.Proc {
+		proc := NewInjectedProc(state.cmd.Cmd.Name, state.cmd.Cmd.Args, state.indextemplate, index)
+		proc.Cmd.Stdout = os.Stdout
+		proc.Cmd.Stderr = os.Stderr
+		return proc
+	}
+	if pe := Parallel(state.total, state.concur, newProc, printed); pe != nil {
+		pe.Exit()
+	}
+}
@@ -0,0 +1,64 @@
+// chris 2016-01-07
+
+// Package prunsleep implements the prunsleep mode, which runs a
+// command after sleeping a random amount of time.
+//
+// See chrispennello.com/go/prun/cmd/prunsleep for its documentation.
+package prunsleep
+
+import (
+	"time"
+
+	"math/rand"
+
+	"chrispennello.com/go/prun/cmd"
+)
+
+// Mode is the prunsleep mode.
+var Mode = &cmd.Mode{
+	Name:     "prunsleep",
+	Synopsis: "Run a command after sleeping a random amount of time.",
+	Args:     []string{"bound"},
+	Main:     run,
+}
+
+var state struct {
+	cmd cmd.State
+
+	// Maximum time that we will sleep before running the specified
+	// program.
+	bound time.Duration
+}
+
+// setup initializes the state given the parsed command line.
+func setup(s cmd.State) {
+	state.cmd = s
+
+	state.bound = state.cmd.Duration(0)
+}
+
+// Layer returns a cmd.Layer that runs the command after sleeping for a
+// pseudo-random duration in [0,bound).  If bound isn't positive, it
+// doesn't sleep at all.  The sleep is cut short if the Proc's context
+// is done, and the command isn't run.
+func Layer(bound time.Duration) cmd.Layer {
+	return func(next cmd.Runner) cmd.Runner {
+		return func(proc *cmd.Proc) *cmd.ProcError {
+			if bound > 0 {
+				t := time.NewTimer(time.Duration(rand.Int63n(bound.Nanoseconds())))
+				defer t.Stop()
+				select {
+				case <-t.C:
+				case <-proc.Context().Done():
+					return &cmd.ProcError{Msg: proc.Context().Err().Error(), Code: 1}
+				}
+			}
+			return next(proc)
+		}
+	}
+}
+
+func run(s cmd.State) {
+	setup(s)
+	s.Run(Layer(state.bound))
+}
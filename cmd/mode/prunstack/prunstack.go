@@ -0,0 +1,106 @@
+// chris 2026-10-18
+
+// Package prunstack implements the prunstack mode, which runs a command
+// through several of the other modes' layers in a single process.
+//
+// See chrispennello.com/go/prun/cmd/prunstack for its documentation.
+package prunstack
+
+import (
+	"time"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfail"
+	"chrispennello.com/go/prun/cmd/mode/prunfor"
+	"chrispennello.com/go/prun/cmd/mode/prunsleep"
+)
+
+// Mode is the prunstack mode.
+var Mode = &cmd.Mode{
+	Name:     "prunstack",
+	Synopsis: "Run a command through several of the other modes in a single process.",
+	Codes: []cmd.Code{
+		{Code: 10, Msg: "-every: Minimum period not yet elapsed."},
+		{Code: 11, Msg: "-every: Error opening, creating, examining, or updating the stat file."},
+		{Code: 12, Msg: "-every: A previous run is still in progress."},
+		{Code: 20, Msg: "-ex: Could not acquire lock."},
+		{Code: 21, Msg: "-ex: Lost the lock while running the command, and stopped it."},
+		{Code: 31, Msg: "-fail: Error opening, creating, or writing to log file."},
+		{Code: 32, Msg: "-fail: Error copying consolidated output to standard error."},
+		{Code: 40, Msg: "-for: Timed out, and the command was killed."},
+		{Code: 41, Msg: "-for: Timed out, and the command exited after being sent the -signal signal."},
+		{Code: 42, Msg: "-idle: Idle, and the command was killed."},
+		{Code: 43, Msg: "-idle: Idle, and the command exited after being sent the -signal signal."},
+	},
+	Flags: flags,
+	Main:  run,
+}
+
+var state struct {
+	// Whether to run the command exclusively, and how.
+	ex     bool
+	exopts prunex.Options
+	// Minimum periodic execution interval to enforce, and how.
+	every     time.Duration
+	everyopts prunevery.Options
+	// Maximum time to sleep before running the command.
+	sleep time.Duration
+	// Maximum number of consecutive failures before emitting output.
+	fail uint64
+	// Limits to apply to the command.
+	limits prunfor.Limits
+}
+
+// flags defines the options.
+func flags() {
+	cmd.Flags.BoolVar(&state.ex, "ex", false, "run the command exclusively, as with prunex")
+	cmd.Flags.DurationVar(&state.every, "every", 0, "minimum `period` between executions, as with prunevery")
+	cmd.Flags.DurationVar(&state.sleep, "sleep", 0, "`bound` on a random sleep before running, as with prunsleep")
+	cmd.Flags.Uint64Var(&state.fail, "fail", 0, "guard output until more than `maxfail` failures, as with prunfail")
+	cmd.Flags.DurationVar(&state.limits.Timelimit, "for", 0, "`timelimit` on the command, as with prunfor")
+	state.limits.Flags()
+	state.exopts.Flags()
+	state.everyopts.Flags()
+	cmd.StateDirVar()
+	cmd.KeyVar()
+}
+
+// layers returns the layers selected by the options, outermost first,
+// for the given command key.
+func layers(key string) []cmd.Layer {
+	var layers []cmd.Layer
+	if state.ex {
+		layers = append(layers, prunex.Layer(key, state.exopts))
+	}
+	if e := state.everyopts; state.every > 0 || e.Schedule != "" || e.SkipRunning {
+		layers = append(layers, prunevery.Layer(key, state.every, state.everyopts))
+	}
+	if state.sleep > 0 {
+		layers = append(layers, prunsleep.Layer(state.sleep))
+	}
+	if state.fail > 0 {
+		layers = append(layers, prunfail.Layer(key, int(state.fail)))
+	}
+	l := state.limits
+	if l.Timelimit > 0 || l.Idle > 0 || l.Group || l.Subreaper {
+		layers = append(layers, prunfor.Layer(l))
+	}
+	return layers
+}
+
+func run(s cmd.State) {
+	if state.every < 0 {
+		cmd.BadArgs("every must be non-negative")
+	}
+	if state.sleep < 0 {
+		cmd.BadArgs("sleep must be non-negative")
+	}
+	for _, check := range []func() error{state.limits.Check, state.exopts.Check, state.everyopts.Check} {
+		if err := check(); err != nil {
+			cmd.BadArgs(err.Error())
+		}
+	}
+	s.Run(layers(s.Key())...)
+}
@@ -0,0 +1,341 @@
+// chris 2026-10-18
+
+// Package prunstate implements the prunstate mode, which inspects the
+// lock, stat, and log files kept by prunex, prunevery, and prunfail.
+//
+// See chrispennello.com/go/prun/cmd/prunstate for its documentation.
+package prunstate
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"path/filepath"
+	"text/tabwriter"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfail"
+)
+
+// Mode is the prunstate mode.
+var Mode = &cmd.Mode{
+	Name:     "prunstate",
+	Synopsis: "Inspect the lock, stat, and log files of prunex, prunevery, and prunfail.",
+	Optional: true,
+	Flags:    flags,
+	Main:     run,
+}
+
+// flags defines the options.
+func flags() {
+	cmd.StateDirVar()
+	cmd.KeyVar()
+}
+
+// timeLayout is the layout with which times are displayed.
+const timeLayout = "2006-01-02 15:04:05 MST"
+
+// kind is a kind of file kept in the state directory.
+type kind struct {
+	// The mode keeping the file.
+	mode string
+	// What surrounds the key in the file's name.
+	prefix, suffix string
+	// keyOf, if non-nil, returns the key given what's surrounded,
+	// if it's valid, for files whose names include more than the
+	// key.
+	keyOf func(s string) (string, bool)
+	// describe describes the state recorded by the file at path.
+	describe func(path string) (string, error)
+	// Whether to omit the file when looking it up if it doesn't
+	// exist, rather than saying so, or if it records nothing, as
+	// when describe returns an empty description.
+	optional bool
+}
+
+var kinds = []kind{
+	{"prunex", prunex.LockPrefix, "", slotKeyOf, describeLock, false},
+	{"prunex", prunex.WaitPrefix, "", pidKeyOf, describeWait, true},
+	{"prunex", prunex.LeasePrefix, "", slotKeyOf, describeLease, true},
+	{"prunevery", prunevery.StatPrefix, "", statKeyOf, describeStat, false},
+	{"prunevery", prunevery.StatPrefix, prunevery.RunSuffix, nil, describeRun, true},
+	{"prunfail", prunfail.LogPrefix, prunfail.LogSuffix, nil, describeLog, false},
+}
+
+// slotKeyOf returns the key of a prunex lock file, which may be one of
+// several slots.
+func slotKeyOf(s string) (string, bool) {
+	key, _ := prunex.ParseSlotKey(s)
+	return key, true
+}
+
+// statKeyOf returns the key of a prunevery stat file, as long as it
+// isn't one of the run, lock, or temporary files, which share its
+// prefix.
+func statKeyOf(s string) (string, bool) {
+	for _, suffix := range []string{prunevery.RunSuffix, prunevery.LockSuffix, prunevery.TempSuffix} {
+		if strings.HasSuffix(s, suffix) {
+			return s, false
+		}
+	}
+	return s, true
+}
+
+// pidKeyOf returns the key of a file whose name begins with a process
+// ID and an underscore.
+func pidKeyOf(s string) (string, bool) {
+	i := strings.Index(s, "_")
+	if i < 0 {
+		return "", false
+	}
+	return s[i+1:], true
+}
+
+// key returns the key naming the file with the given name, if it is of
+// this kind.
+func (k kind) key(name string) (string, bool) {
+	if !strings.HasPrefix(name, k.prefix) || !strings.HasSuffix(name, k.suffix) {
+		return "", false
+	}
+	key := strings.TrimSuffix(strings.TrimPrefix(name, k.prefix), k.suffix)
+	if k.keyOf != nil {
+		var ok bool
+		if key, ok = k.keyOf(key); !ok {
+			return "", false
+		}
+	}
+	return key, key != ""
+}
+
+// paths returns the paths of the files of this kind in dir named by the
+// given key.  If there are none, the path of the file whose name includes
+// just the key, if that's of this kind, is returned anyway.
+func (k kind) paths(dir, key string) []string {
+	exact := filepath.Join(dir, k.prefix+key+k.suffix)
+	var paths []string
+	if k.keyOf != nil {
+		matches, _ := filepath.Glob(filepath.Join(dir, k.prefix+"*"+k.suffix))
+		for _, path := range matches {
+			if other, ok := k.key(filepath.Base(path)); ok && other == key {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		if other, ok := k.key(filepath.Base(exact)); ok && other == key {
+			paths = append(paths, exact)
+		}
+	}
+	return paths
+}
+
+// describeLock describes a prunex lock file, noting which slot it is,
+// if the lock has several.
+func describeLock(path string) (string, error) {
+	holders, err := prunex.ReadHolders(path)
+	if err != nil {
+		return "", err
+	}
+	var d string
+	name := strings.TrimPrefix(filepath.Base(path), prunex.LockPrefix)
+	if _, slot := prunex.ParseSlotKey(name); slot != 0 {
+		d = fmt.Sprintf("slot %d ", slot)
+	}
+	stale := true
+	f := make([]string, len(holders))
+	for i, h := range holders {
+		f[i] = h.String()
+		stale = stale && h.Stale()
+	}
+	by := "by " + strings.Join(f, "; ")
+	if holders[0].Shared {
+		by = "shared " + by
+	}
+	if stale {
+		return d + "stale, held " + by, nil
+	}
+	return d + "locked " + by, nil
+}
+
+// describeWait describes a file recording a process waiting for a
+// prunex lock.
+func describeWait(path string) (string, error) {
+	h, err := prunex.ReadHolder(path)
+	if err != nil {
+		return "", err
+	}
+	if h.Stale() {
+		return fmt.Sprintf("stale, waited by %s", h), nil
+	}
+	return fmt.Sprintf("waiting: %s", h), nil
+}
+
+// describeLease describes a prunex lease file, as kept by the lease
+// backend, if it's kept in the state directory.
+func describeLease(path string) (string, error) {
+	h, expires, err := prunex.ReadLease(path)
+	if err != nil {
+		return "", err
+	}
+	var d string
+	name := strings.TrimPrefix(filepath.Base(path), prunex.LeasePrefix)
+	if _, slot := prunex.ParseSlotKey(name); slot != 0 {
+		d = fmt.Sprintf("slot %d ", slot)
+	}
+	if expires.Before(time.Now()) {
+		return d + fmt.Sprintf("expired %s, leased by %s", expires.Format(timeLayout), h), nil
+	}
+	return d + fmt.Sprintf("leased by %s until %s", h, expires.Format(timeLayout)), nil
+}
+
+// describeStat describes a prunevery stat file.
+func describeStat(path string) (string, error) {
+	r, err := prunevery.ReadRecord(path)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	clocks := prunevery.ReadClocks()
+	// When it last ran by the wall clock as of now, whatever the clock
+	// by which the period is measured.
+	last := r
+	last.Clock = prunevery.ClockBoot
+	d := "never run"
+	if !r.Last.IsZero() {
+		d = "last run " + last.Adjust(now, clocks).Last.Format(timeLayout)
+	}
+	switch r.Outcome {
+	case prunevery.Success:
+		d += ", succeeded"
+	case prunevery.Failure:
+		d += fmt.Sprintf(", %d consecutive failures, last %s", r.Failures, r.Failed.Format(timeLayout))
+	}
+	if n := len(r.History); n > 0 {
+		a := r.History[n-1]
+		d += fmt.Sprintf(", last took %s and exited %d", a.Elapsed, a.Status)
+	}
+	if r.Skips > 0 {
+		d += fmt.Sprintf(", %d consecutive skips", r.Skips)
+	}
+	a := r.Adjust(now, clocks)
+	if a.Skewed(now) {
+		d += ", stamped in the future"
+	}
+	if r.Period == 0 && r.Schedule == "" {
+		return d, nil
+	}
+	if r.Period != 0 {
+		d += fmt.Sprintf(", period %s", r.Period)
+	}
+	if r.Schedule != "" {
+		d += fmt.Sprintf(", schedule %q", r.Schedule)
+	}
+	if r.SameDay {
+		d += " same day"
+	}
+	if r.Clock == prunevery.ClockAwake {
+		d += " awake"
+	}
+	if next := a.Next(now); next.After(now) {
+		d += ", next eligible " + next.Format(timeLayout)
+	} else {
+		d += ", eligible now"
+	}
+	return d, nil
+}
+
+// describeRun describes a prunevery run file, or returns an empty
+// description if no run is in progress.
+func describeRun(path string) (string, error) {
+	r, ok, err := prunevery.ReadRun(path)
+	if err != nil || !ok {
+		return "", err
+	}
+	return "running as " + r.String(), nil
+}
+
+// describeLog describes a prunfail log file.
+func describeLog(path string) (string, error) {
+	failures, last, err := prunfail.ReadFailures(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d consecutive failures, last run %s", failures, last.Format(timeLayout)), nil
+}
+
+// describe writes a line describing the file of the given kind at path,
+// named by the given key, if it exists or the kind isn't optional.
+func describe(w io.Writer, k kind, key, path string) {
+	d, err := k.describe(path)
+	if os.IsNotExist(err) {
+		if k.optional {
+			return
+		}
+		d = "none"
+	} else if err != nil {
+		d = "error: " + err.Error()
+	} else if d == "" && k.optional {
+		return
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\n", k.mode, key, d)
+}
+
+// list describes all the files in the state directory, if it exists.
+func list(w io.Writer, dir string) error {
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, k := range kinds {
+		for _, name := range names {
+			if key, ok := k.key(name); ok {
+				describe(w, k, key, filepath.Join(dir, name))
+			}
+		}
+	}
+	return nil
+}
+
+// lookup describes the files named by the given key.
+func lookup(w io.Writer, dir, key string) {
+	for _, k := range kinds {
+		for _, path := range k.paths(dir, key) {
+			describe(w, k, key, path)
+		}
+	}
+}
+
+func run(s cmd.State) {
+	dir, err := cmd.LookupStateDir()
+	if err != nil {
+		(&cmd.ProcError{Msg: err.Error(), Code: 1}).Exit()
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "MODE\tKEY\tSTATE\n")
+	switch {
+	case s.Cmd.Name != "":
+		lookup(w, dir, s.Key())
+	case cmd.ExplicitKey() != "":
+		lookup(w, dir, cmd.ExplicitKey())
+	default:
+		err = list(w, dir)
+	}
+	w.Flush()
+	if err != nil {
+		(&cmd.ProcError{Msg: err.Error(), Code: 1}).Exit()
+	}
+}
@@ -0,0 +1,113 @@
+// chris 2026-10-18
+
+package prunstate
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"io/ioutil"
+	"path/filepath"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfail"
+)
+
+// testStateDir points the state directory at a new temporary directory,
+// returning it and a function that removes it again.
+func testStateDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "prunstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := os.LookupEnv(cmd.StateDirEnv)
+	os.Setenv(cmd.StateDirEnv, dir)
+	return dir, func() {
+		if ok {
+			os.Setenv(cmd.StateDirEnv, old)
+		} else {
+			os.Unsetenv(cmd.StateDirEnv)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// testWrite writes the file with the given name and contents in dir.
+func testWrite(t *testing.T, dir, name, data string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	host, _ := os.Hostname()
+	holder := fmt.Sprintf("pid %d\nhost %s\ncommand \"sh sync.sh\"\nmode exclusive\n", os.Getpid(), host)
+	testWrite(t, dir, prunex.LockPrefix+"k", holder)
+	testWrite(t, dir, prunex.LockPrefix+"k.slot2", holder)
+	testWrite(t, dir, prunevery.StatPrefix+"k", "period 1h0m0s\n")
+	testWrite(t, dir, prunevery.StatPrefix+"k"+prunevery.RunSuffix, "")
+	testWrite(t, dir, prunevery.StatPrefix+"k"+prunevery.LockSuffix, "")
+	testWrite(t, dir, prunfail.LogPrefix+"j"+prunfail.LogSuffix, "output\nfail 2\n")
+
+	var b bytes.Buffer
+	if err := list(&b, dir); err != nil {
+		t.Fatalf("list errored: %v\n", err)
+	}
+	t.Logf("listed\n%s", b.String())
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	want := []string{
+		"prunex\tk\tlocked by pid",
+		"prunex\tk\tslot 2 locked by pid",
+		"prunevery\tk\tlast run",
+		"prunfail\tj\t2 consecutive failures",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("listed %d files, not %d\n", len(lines), len(want))
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Errorf("listed %q, expected %q\n", line, want[i])
+		}
+	}
+	if !strings.Contains(lines[0], ": sh sync.sh") || !strings.Contains(lines[2], "period 1h0m0s, next eligible") {
+		t.Errorf("listed %q\n", b.String())
+	}
+
+	// Looking up a key describes only its files, and says when one
+	// that isn't optional doesn't exist.
+	b.Reset()
+	lookup(&b, dir, "j")
+	if s := b.String(); !strings.Contains(s, "prunex\tj\tnone\n") || !strings.Contains(s, "prunfail\tj\t2 consecutive failures") || strings.Contains(s, "\tk\t") {
+		t.Errorf("looked up %q\n", s)
+	}
+}
+
+func TestNoStateDir(t *testing.T) {
+	dir, done := testStateDir(t)
+	defer done()
+	missing := filepath.Join(dir, "missing")
+	os.Setenv(cmd.StateDirEnv, missing)
+
+	// Inspecting a state directory that doesn't exist doesn't create
+	// it.
+	got, err := cmd.LookupStateDir()
+	if err != nil || got != missing {
+		t.Errorf("looked up state directory %q, %v\n", got, err)
+	}
+	var b bytes.Buffer
+	if err := list(&b, got); err != nil || b.Len() != 0 {
+		t.Errorf("listed %q, %v\n", b.String(), err)
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("state directory created: %v\n", err)
+	}
+}
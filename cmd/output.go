@@ -0,0 +1,127 @@
+// chris 2026-10-18 Output copying.
+
+package cmd
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// outputDelay is how long Wait waits for output to finish being copied
+// once the command has exited.  Copying doesn't finish until all
+// processes holding the write end of the pipe, such as the command's
+// orphaned descendants, have closed it.
+const outputDelay = time.Second
+
+// sameWriter reports whether the writers are the same, without
+// panicking on incomparable writers.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// copier copies output from the read end of a pipe to a writer until
+// it's stopped.
+type copier struct {
+	r  *os.File
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Write writes to the writer, unless the copier has been stopped.
+func (c *copier) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.w == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return c.w.Write(b)
+}
+
+// stop stops copying, closing the read end of the pipe.  Once it
+// returns, nothing more is written to the writer.
+func (c *copier) stop() {
+	c.mu.Lock()
+	c.w = nil
+	c.mu.Unlock()
+	c.r.Close()
+}
+
+// output returns a file for the command to write to in place of w.  If
+// w is nil or already a file, it is returned as is.  Otherwise, output
+// returns the write end of a pipe and copies anything written to it to
+// w.
+func (p *Proc) output(w io.Writer) (io.Writer, error) {
+	if w == nil {
+		return nil, nil
+	}
+	if _, ok := w.(*os.File); ok {
+		return w, nil
+	}
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	p.closeAfterStart = append(p.closeAfterStart, pw)
+	c := &copier{r: r, w: w}
+	p.copiers = append(p.copiers, c)
+	p.copying.Add(1)
+	go func() {
+		defer p.copying.Done()
+		defer r.Close()
+		io.Copy(c, r) // Don't care if this errors.
+	}()
+	return pw, nil
+}
+
+// pipeOutput replaces the command's standard output and error with
+// files, so that Wait can wait for any output to finish being copied.
+// The os/exec package would otherwise copy it, but Wait doesn't use
+// exec.Cmd's Wait.  If standard output and error are the same writer,
+// they share a pipe, so that writes to them stay in order.
+func (p *Proc) pipeOutput() error {
+	same := sameWriter(p.Cmd.Stdout, p.Cmd.Stderr)
+	var err error
+	if p.Cmd.Stdout, err = p.output(p.Cmd.Stdout); err != nil {
+		return err
+	}
+	if same {
+		p.Cmd.Stderr = p.Cmd.Stdout
+		return nil
+	}
+	p.Cmd.Stderr, err = p.output(p.Cmd.Stderr)
+	return err
+}
+
+// closePipes closes our copies of the write ends of the pipes once the
+// command has started with its own.
+func (p *Proc) closePipes() {
+	for _, f := range p.closeAfterStart {
+		f.Close()
+	}
+	p.closeAfterStart = nil
+}
+
+// waitOutput waits up to outputDelay for any output to finish being
+// copied.  Any output still being copied after that is discarded, so
+// that nothing more is written once Wait returns.
+func (p *Proc) waitOutput() {
+	copied := make(chan struct{})
+	go func() {
+		p.copying.Wait()
+		close(copied)
+	}()
+	select {
+	case <-copied:
+	case <-time.After(outputDelay):
+		for _, c := range p.copiers {
+			c.stop()
+		}
+	}
+}
@@ -3,12 +3,14 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 
 	"os/exec"
 )
@@ -33,9 +35,13 @@ func (pe *ProcError) Print() {
 }
 
 // Exit prints the message, if one is present, to standard error, and
-// exits the parent process with the exit code.
+// exits the parent process with the exit code, or 255 if it's
+// UnknownCode.
 func (pe *ProcError) Exit() {
 	pe.Print()
+	if pe.Code == UnknownCode {
+		os.Exit(255)
+	}
 	os.Exit(pe.Code)
 }
 
@@ -44,10 +50,49 @@ func (pe *ProcError) Exit() {
 type Proc struct {
 	*exec.Cmd
 
+	// Signals received by the parent process to relay to the
+	// command while it runs.  If empty, no signals are relayed.
+	Relay []os.Signal
+	// How long to wait after relaying a signal before killing the
+	// command.  If zero, the command is never killed.
+	Grace time.Duration
+	// Whether to start the command in its own process group, in
+	// which case signals and kills target the whole group rather
+	// than just the command.  Process groups are supported on
+	// Unix-like systems only.
+	Group bool
+
 	command string
 	args    []string
+
+	// Context that, once done, stops the command, and the resulting
+	// error, if the context was done before the command exited.
+	ctx    context.Context
+	ctxMu  sync.Mutex
+	ctxErr error
+
+	started  chan struct{}
+	exited   chan struct{}
+	exitOnce sync.Once
+
+	// When the command was started, and its Result once waited on.
+	start  time.Time
+	result *Result
+
+	// Output pipes and the goroutines copying from them.
+	closeAfterStart []*os.File
+	copiers         []*copier
+	copying         sync.WaitGroup
 }
 
+// procs records the process IDs of the commands that Procs have
+// started and not yet waited on, so that Reap can tell them apart from
+// orphaned descendants.
+var procs = struct {
+	sync.Mutex
+	pids map[int]bool
+}{pids: make(map[int]bool)}
+
 // filterErrNoEnt replaces the given error with ErrNoEnt if appropriate
 // and returns the original error otherwise.
 func filterErrNoEnt(err error) error {
@@ -66,20 +111,91 @@ func filterErrNoEnt(err error) error {
 // NewProc returns the Proc struct to execute the named command with its
 // optional arguments.
 //
-// Remember to set the Cmd Stdout and Stderr or capture with the
-// corresponding pipes.  Otherwise, all output will be discarded.
+// Remember to set the Cmd Stdout and Stderr.  Otherwise, all output
+// will be discarded.  Unlike with exec.Cmd, don't use its
+// corresponding pipes: they are closed before the output has been
+// copied.  Writers other than files are instead copied from by Start
+// and waited on by Wait.
+//
+// The Proc relays RelaySignals to the command, with the grace period
+// given by the common -grace option if Parse has been called, or
+// DefaultGrace otherwise.
 func NewProc(command string, args []string) *Proc {
+	return NewProcContext(context.Background(), command, args)
+}
+
+// NewProcContext is like NewProc, but the command is stopped if the
+// context is done before it exits.  Stopping the command follows the
+// same sequence as for a relayed signal: the command is sent SIGTERM
+// and, if it has not exited within the grace period, it is killed.  If
+// the context is done before the command starts, it isn't started.
+func NewProcContext(ctx context.Context, command string, args []string) *Proc {
+	if ctx == nil {
+		panic("nil Context")
+	}
 	return &Proc{
 		Cmd:     exec.Command(command, args...),
+		Relay:   RelaySignals,
+		Grace:   grace,
 		command: command,
 		args:    args,
+		ctx:     ctx,
+		started: make(chan struct{}),
+		exited:  make(chan struct{}),
 	}
 }
 
+// Context returns the Proc's context.
+func (p *Proc) Context() context.Context {
+	return p.ctx
+}
+
+// WithCancel replaces the Proc's context with one that is also done
+// once the returned function is called, so that a Layer can stop the
+// command on its own account just as if the context had been done.  It
+// must be called before the command is started.
+func (p *Proc) WithCancel() context.CancelFunc {
+	ctx, cancel := context.WithCancel(p.ctx)
+	p.ctx = ctx
+	return cancel
+}
+
 // Start wraps the underlying exec.Cmd Start, filtering any returned
-// errors and transforming them into an ErrNoEnt if appropriate.
+// errors and transforming them into an ErrNoEnt if appropriate.  Once
+// the command has started, signals are relayed to it until it is
+// waited on.  If the Proc's context is already done, Start returns its
+// error without starting the command.
 func (p *Proc) Start() error {
-	return filterErrNoEnt(p.Cmd.Start())
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.Group {
+		setGroup(p.Cmd)
+	}
+	if err := p.pipeOutput(); err != nil {
+		p.closePipes()
+		return err
+	}
+	p.start = time.Now()
+	procs.Lock()
+	err := p.Cmd.Start()
+	if err == nil {
+		procs.pids[p.Cmd.Process.Pid] = true
+	}
+	procs.Unlock()
+	p.closePipes()
+	if err != nil {
+		return filterErrNoEnt(err)
+	}
+	close(p.started)
+	p.watch()
+	return nil
+}
+
+// Started returns a channel that is closed once the command has
+// started.
+func (p *Proc) Started() <-chan struct{} {
+	return p.started
 }
 
 // StartError wraps Start.  It consolidates the various errors that can
@@ -123,48 +239,107 @@ func (p *Proc) String() string {
 	return fmt.Sprintf("%s %s", p.command, strings.Join(p.args, " "))
 }
 
-// Wait calls Wait on the underlying exec.Cmd's Process and, if the
-// operating system supports it, returns the exit status.
+// ExitError describes a command that terminated unsuccessfully, or that
+// was stopped because its context was done.  It is returned by Wait
+// along with its Result.
 //
-// If an error occurs when waiting for the underlying process, the exit
-// status will be -2, and the error will be returned.  If the operating
-// system does not support determining the exit status, but the program
-// exited successfully, the exit status will be 0.  If the operating
-// system does not support determining the exit status and the program
-// exited unsuccessfully, the exit status will be -1.
-func (p *Proc) Wait() (exitStatus int, err error) {
-	var ps *os.ProcessState
-	ps, err = p.Cmd.Process.Wait()
-	if err != nil {
-		return -2, err
+// If the command was stopped because its context was done, Context is
+// the context's error, context.Canceled or context.DeadlineExceeded,
+// and errors.Is reports it, even if the command then exited
+// successfully.  Otherwise, if the command was killed by a signal,
+// Signal is that signal.  Otherwise, the command exited with the
+// non-zero Code.
+type ExitError struct {
+	*Result
+	// Error of the context, if it was done before the command
+	// exited.
+	Context error
+}
+
+func (e *ExitError) Error() string {
+	how := fmt.Sprintf("exit status %d", e.Code)
+	if e.Code == UnknownCode {
+		how = "exit status unknown"
 	}
-	ws, ok := ps.Sys().(syscall.WaitStatus)
-	if ok {
-		return ws.ExitStatus(), nil
+	if e.Signal != 0 {
+		how = fmt.Sprintf("killed by signal %d", int(e.Signal))
+	}
+	if e.Context != nil {
+		return fmt.Sprintf("%s (%s)", e.Context, how)
+	}
+	return how
+}
+
+// Unwrap returns the context's error, if any.
+func (e *ExitError) Unwrap() error {
+	return e.Context
+}
+
+// Wait calls Wait on the underlying exec.Cmd's Process and returns the
+// command's Result.  It then waits briefly for any output to finish
+// being copied.
+//
+// If the command exited unsuccessfully, or was stopped because the
+// Proc's context was done, however it then exited, the error is an
+// *ExitError.  If an error occurs when waiting for the underlying process, the
+// Result is nil, and that error is returned instead.
+func (p *Proc) Wait() (*Result, error) {
+	ps, err := p.Cmd.Process.Wait()
+	end := time.Now()
+	procs.Lock()
+	delete(procs.pids, p.Cmd.Process.Pid)
+	procs.Unlock()
+	p.exitOnce.Do(func() { close(p.exited) })
+	p.waitOutput()
+	if err != nil {
+		return nil, err
 	}
-	if ps.Success() {
-		return 0, nil
+	p.result = newResult(ps, p.start, end)
+	ctxErr := p.contextErr()
+	if p.result.Success() && ctxErr == nil {
+		return p.result, nil
 	}
-	return -1, nil
+	return p.result, &ExitError{Result: p.result, Context: ctxErr}
+}
+
+// Result returns the command's Result once it has been waited on, and
+// nil otherwise.
+func (p *Proc) Result() *Result {
+	return p.result
 }
 
 // WaitError wraps Wait.  It consolidates the various errors that can be
 // returned into a single *ProcError.
+//
+// If the command was killed by a signal or stopped because its context
+// was done, the message notes it.  If it was stopped but exited
+// successfully anyway, the exit status is 1, so that it still failed.
 func (p *Proc) WaitError() *ProcError {
-	exitStatus, err := p.Wait()
-	if err != nil {
+	_, err := p.Wait()
+	if err == nil {
+		return nil
+	}
+	ee, ok := err.(*ExitError)
+	if !ok {
 		return &ProcError{
 			Msg:  err.Error(),
 			Code: 1,
 		}
 	}
-	if exitStatus != 0 {
+	if ee.Signal != 0 || ee.Context != nil {
+		code := ee.Code
+		if code == 0 {
+			code = 1
+		}
 		return &ProcError{
-			Msg:  "",
-			Code: exitStatus,
+			Msg:  fmt.Sprintf("%s: %s\n", p.command, ee),
+			Code: code,
 		}
 	}
-	return nil
+	return &ProcError{
+		Msg:  "",
+		Code: ee.Code,
+	}
 }
 
 // WaitExit wraps WaitError and, given a *ProcError, exits the parent
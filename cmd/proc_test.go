@@ -0,0 +1,94 @@
+// chris 2026-10-18
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testWait(t *testing.T, ctx context.Context, command string, args ...string) (int, *ExitError) {
+	t.Helper()
+	p := NewProcContext(ctx, command, args)
+	p.Relay = nil
+	if err := p.Start(); err != nil {
+		t.Fatalf("%s: start errored: %v\n", command, err)
+	}
+	r, err := p.Wait()
+	if r == nil {
+		t.Fatalf("%s: wait errored: %v\n", command, err)
+	}
+	t.Logf("%s: %s\n", command, r)
+	if r.End.Before(r.Start) || r.Start.IsZero() {
+		t.Errorf("%s: bad start %v and end %v\n", command, r.Start, r.End)
+	}
+	if err == nil {
+		return r.Code, nil
+	}
+	ee, ok := err.(*ExitError)
+	if !ok || ee.Result != r {
+		t.Fatalf("%s: wait errored: %v\n", command, err)
+	}
+	return r.Code, ee
+}
+
+func TestWaitExitError(t *testing.T) {
+	ctx := context.Background()
+	if status, ee := testWait(t, ctx, "true"); status != 0 || ee != nil {
+		t.Errorf("true: status %d, error %v\n", status, ee)
+	}
+	if status, ee := testWait(t, ctx, "sh", "-c", "exit 3"); status != 3 || ee == nil || ee.Signal != 0 || ee.Context != nil {
+		t.Errorf("exit 3: status %d, error %v\n", status, ee)
+	}
+	if status, ee := testWait(t, ctx, "sh", "-c", "kill -KILL $$"); status != 128+9 || ee == nil || ee.Signal != syscall.SIGKILL || ee.Context != nil {
+		t.Errorf("kill: status %d, error %v\n", status, ee)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	status, ee := testWait(t, ctx, "sleep", "10")
+	if status != 128+15 || ee == nil || ee.Signal != syscall.SIGTERM || !errors.Is(ee, context.DeadlineExceeded) {
+		t.Errorf("deadline: status %d, error %v\n", status, ee)
+	}
+
+	// The command handles SIGTERM by exiting successfully, but the
+	// context being done isn't lost.
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	status, ee = testWait(t, ctx, "sh", "-c", "trap 'exit 0' TERM; sleep 5 & wait")
+	if status != 0 || ee == nil || ee.Signal != 0 || !errors.Is(ee, context.DeadlineExceeded) {
+		t.Errorf("trapped deadline: status %d, error %v\n", status, ee)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	p := NewProcContext(ctx, "true", nil)
+	if err := p.Start(); err != context.Canceled {
+		t.Errorf("canceled: start returned %v\n", err)
+	}
+}
+
+func TestWaitOutput(t *testing.T) {
+	// Output written by a descendant once the command has exited
+	// isn't copied after Wait returns.
+	var out bytes.Buffer
+	p := NewProc("sh", []string{"-c", "(sleep 2; echo late) & echo early"})
+	p.Relay = nil
+	p.Cmd.Stdout = &out
+	if err := p.Start(); err != nil {
+		t.Fatalf("start errored: %v\n", err)
+	}
+	if _, err := p.Wait(); err != nil {
+		t.Fatalf("wait errored: %v\n", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if out.String() != "early\n" {
+		t.Errorf("output %q, expected %q\n", out.String(), "early\n")
+	}
+}
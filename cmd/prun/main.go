@@ -0,0 +1,80 @@
+// chris 2026-10-18
+
+// prun runs any of the prun utilities as a subcommand, or mode.
+//
+//	usage: prun mode [option ...] [argument ...] command [argument ...]
+//	       prun help [mode]
+//
+// The modes are the following, and may be given with or without their
+// "prun" prefix.
+//
+//	every     prunevery
+//	ex        prunex
+//	fail      prunfail
+//	for       prunfor
+//	lockd     prunlockd
+//	parallel  prunparallel
+//	sleep     prunsleep
+//	stack     prunstack
+//	state     prunstate
+//
+// For example, these two invocations are equivalent.
+//
+//	prunfor 10m job
+//	prun for 10m job
+//
+// Each mode takes exactly the same options and arguments, behaves
+// exactly the same way, and keeps exactly the same lock, stat, and log
+// files as the corresponding utility.  See their documentation for
+// details.
+//
+// If prun is invoked under the name of one of the utilities, it runs
+// that mode.  So, you can install just prun and symbolically link to it
+// under the names of the utilities.
+//
+//	ln -s prun prunfor
+//
+// The state mode takes no command, or, to look up the state of a given
+// command line, an optional one.  For example,
+//
+//	prun state
+//
+// lists the lock, stat, and log files of every command.  Likewise, the
+// lockd mode takes an optional command.
+//
+// prun help lists all the modes along with their exit codes.  prun help
+// mode displays the usage of the given mode.
+//
+// Diagnostics
+//
+// If invoked with invalid arguments or with an unknown mode, prun
+// returns with exit code 2.  Otherwise, it returns with the exit code of
+// the mode.
+package main
+
+import (
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfail"
+	"chrispennello.com/go/prun/cmd/mode/prunfor"
+	"chrispennello.com/go/prun/cmd/mode/prunlockd"
+	"chrispennello.com/go/prun/cmd/mode/prunparallel"
+	"chrispennello.com/go/prun/cmd/mode/prunsleep"
+	"chrispennello.com/go/prun/cmd/mode/prunstack"
+	"chrispennello.com/go/prun/cmd/mode/prunstate"
+)
+
+func main() {
+	cmd.Dispatch(
+		prunevery.Mode,
+		prunex.Mode,
+		prunfail.Mode,
+		prunfor.Mode,
+		prunlockd.Mode,
+		prunparallel.Mode,
+		prunsleep.Mode,
+		prunstack.Mode,
+		prunstate.Mode,
+	)
+}
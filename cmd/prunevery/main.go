@@ -2,10 +2,15 @@
 
 // prunevery enforces a minimum period between executions of a command.
 //
-//	usage: prunevery period command [argument ...]
+//	usage: prunevery [-awake] [-grace duration]
+//	       [-onsuccess [-retry backoff]]
+//	       [-schedule schedule [-sameday]] [-skew clamp|run]
+//	       [-skiprunning] [-statedir dir]
+//	       [key option ...] period command [argument ...]
 //
 // period is a non-negative time.Duration.  If period is zero, no
-// minimum period will be enforced.
+// minimum period will be enforced, though a schedule or -skiprunning
+// may be.
 //
 // Sample Usage
 //
@@ -23,22 +28,184 @@
 // can be reasonably sure that it'll run on this schedule, even though
 // the laptop is most often sleeping.
 //
+// Schedules
+//
+// A minimum period drifts: after a run at 9:17, a period of 8h next
+// allows a run at 17:17, then at 1:17, and so on.  With -schedule,
+// prunevery instead runs the command at most once per window of a
+// cron-style schedule, each window beginning at a time given by the
+// schedule, in local time, and lasting until the next.  So the command
+// runs the first time prunevery is invoked in each window, however late,
+// as long as it hasn't already.
+//
+//	*/15 * * * * prunevery -schedule '0 2 * * *' 0 sh sync.sh
+//
+// Above, sync.sh runs once a day, the first time cron runs prunevery at
+// or after 2:00, or, if the laptop was sleeping, whenever it next wakes.
+// The schedule is a cron expression with five fields, giving the
+// minute, hour, day of the month, month, and day of the week, as
+// described in crontab(5), or one of @hourly, @daily, @midnight,
+// @weekly, @monthly, @yearly, and @annually.  For instance:
+//
+//	@daily            once per calendar day
+//	@weekly           once per calendar week, beginning on Sunday
+//	0 2 * * *         once per day, at or after 2:00
+//	0 9 * * mon-fri   once per weekday, at or after 9:00, though a
+//	                  missed Friday is made up over the weekend,
+//	                  unless -sameday is given
+//
+// With -sameday, each window instead lasts at most until the end of the
+// day on which it begins, so that a command missed on one day isn't
+// made up on the next unless another window begins then.
+//
+// A schedule may be combined with a nonzero period, in which case both
+// are enforced.  A command that has never run, as far as the stat file
+// records, waits for a window that begins at or after it was first
+// recorded, within the same minute.
+//
+// Stamping on Success
+//
+// By default, the period is stamped as soon as the command is run, so
+// that a run that fails still counts toward the period, and the command
+// isn't tried again until the period has elapsed.  With -onsuccess, the
+// period is instead stamped only once the command succeeds, so that a
+// failed sync, for instance, is tried again the next time cron runs
+// prunevery.
+//
+//	*/15 * * * * prunevery -onsuccess -retry 15m 8h sh sync.sh
+//
+// With -retry, a failed run is instead not tried again until the given
+// time.Duration has elapsed since it failed, doubling with each further
+// consecutive failure, up to the period.  So, above, sync.sh is retried
+// 15 minutes, then 30 minutes, then an hour after failing, and so on.
+//
+// With -schedule, the backoff likewise never extends past the beginning
+// of the next window.
+//
+// Note that with -onsuccess, nothing is stamped while the command runs,
+// so that another invocation of prunevery might run it concurrently.
+// Use -skiprunning, as below, to prevent that.
+//
+// Concurrent Invocations
+//
+// prunevery checks and stamps the period while holding a lock on a lock
+// file, named like the stat file, followed by ".lock", so that of
+// several invocations at once, such as from cron jobs firing together,
+// only one decides to run the command.  Locking is supported on
+// Unix-like systems only (the underlying implementation uses
+// syscall.Flock).  The stat file itself is replaced atomically, by
+// writing a new one, named like it followed by ".new", and renaming it
+// into place, so that it's never seen partially written.
+//
+// While the command runs, prunevery also holds a lock on a run file,
+// named like the stat file, followed by ".running", which records its
+// process ID and when it started.  With -skiprunning, prunevery refuses
+// to run the command while a previous run still holds the lock, even
+// if the period has elapsed, and exits with exit code 12.  The previous
+// run needn't have been given -skiprunning.  -skiprunning applies even
+// if period is zero and there's no schedule, so that
+//
+//	* * * * * prunevery -skiprunning 0 sh sync.sh
+//
+// starts sync.sh every minute unless it's still running.
+//
+// Clocks
+//
+// Setting the wall clock, say by NTP or by restoring a virtual machine,
+// would otherwise throw the period off: set forward, it would let the
+// command run early, and set back, it would hold the command off for as
+// long as the clock was set back by.  So on Linux, when stamping the
+// period, prunevery also records the boot ID and CLOCK_BOOTTIME, which
+// measures the time since boot, including time spent suspended, and
+// which setting the wall clock doesn't affect.  As long as the machine
+// hasn't rebooted since, the period is measured by CLOCK_BOOTTIME
+// rather than by the wall clock.
+//
+// With -awake, the period is instead measured by CLOCK_MONOTONIC, which
+// excludes time spent suspended, so that only time the machine is awake
+// counts toward the period.  In the laptop scenario above, that means
+// that sync.sh runs after 8 hours of use, rather than 8 hours of sleep.
+// -awake can't be combined with -schedule, whose windows are given by
+// the wall clock.
+//
+// Across reboots, and on other systems, the period is measured by the
+// wall clock.  If the stat file is then found to have been stamped in
+// the future, prunevery logs it, and, by default, or with -skew clamp,
+// stamps the period now, so that the command doesn't run until the
+// period has elapsed from now.  With -skew run, prunevery instead runs
+// the command right away, as if it had never been stamped.  A setting
+// forward of the clock across a reboot can't be detected.
+//
 // Stat File
 //
 // prunevery enforces the minimum period execution interval by means of
 // examining and updating the modification time on a stat file.  The
-// stat file is stored in the default temporary directory (via
-// os.TempDir).
-//
-// The stat file name is generated by producing a a deterministic and
+// stat file is stored in the state directory, as described in the
+// documentation of chrispennello.com/go/prun/cmd, so that it survives
+// reboots.  A stat file recording a command that has never been
+// stamped, because it has only failed with -onsuccess, has the
+// modification time of the Unix epoch.
+//
+// The stat file also records the period and what became of recent
+// runs, so that prunstate, or any other tool, can display them and when
+// the command is next eligible to run.  Each line holds a name and a
+// value, separated by a space, and lines with unknown names are
+// ignored.
+//
+//	period 8h0m0s                   the period enforced
+//	schedule 0 2 * * *              the schedule given by -schedule
+//	sameday true                    whether -sameday was given
+//	created 2026-10-18T06:00:01Z    when first recorded
+//	start 2026-10-18T06:00:01Z      when the last run started
+//	finish 2026-10-18T06:02:13Z     when the last run finished
+//	status 0                        and its exit status
+//	outcome success                 success or failure
+//	failures 2                      consecutive failures, if any
+//	failed 2026-10-18T06:02:13Z     and when the last one was
+//	retry 10m0s                     the backoff given by -retry
+//	skips 3                         consecutive invocations skipped
+//	clock boot                      boot, or with -awake, awake
+//	bootid 0f9c4e1d-...             the boot ID when stamped
+//	boottime 3h2m1.5s               CLOCK_BOOTTIME when stamped
+//	monotonic 2h1m0.5s              CLOCK_MONOTONIC when stamped
+//
+// Times are in RFC 3339 format, and durations in the format of Go's
+// time.ParseDuration.  These are followed by a history of up to the
+// last 10 runs, oldest first, one per line, each giving when the run
+// started, how long it took, and its exit status.
+//
+//	history 2026-10-18T06:00:01Z 2m12.004s 0
+//
+// A stat file written by an older version of prunevery may be empty or
+// record only some of these, and an invocation that skips running the
+// command records nothing but the skip.
+//
+// The stat file name is generated by producing a deterministic and
 // reasonably human-readable string that identifies the command being
 // run.  All non-word characters are consolidated between the command
 // and its arguments and are replaced with underscores.
 //
-// If the length of this string exceeds a reasonable limit, then it will
-// be truncated and the suffix will be a hash of the full string so as
-// to stay within the length limit, but still uniquely and
-// deterministically identify the given command and its arguments.
+// To this string is appended a version number and a hash of the exact
+// command and arguments, so that it uniquely and deterministically
+// identifies them, even if they differ only in punctuation or in how
+// the arguments are split.  If need be, the human-readable part is
+// truncated to stay within a reasonable length limit.
+//
+// A stat file written by an older version of prunevery, named without
+// the version number and hash, in the state directory or the default
+// temporary directory, is moved into place the first time it's needed,
+// so that the period carries over.
+//
+// The key options -key, -keyargs, and -keycmd instead name the file
+// explicitly or by only some of the arguments, as described in the
+// documentation of chrispennello.com/go/prun/cmd.
+//
+// Signals
+//
+// SIGHUP, SIGINT, and SIGTERM received by prunevery are relayed to the
+// command.  If the command has not exited within the -grace
+// time.Duration (by default, 5s) after the first relayed signal, it is
+// killed.
 //
 // Diagnostics
 //
@@ -50,6 +217,7 @@
 //	 10 Minimum period not yet elapsed.
 //	 11 Error opening, creating, examining, or updating the stat
 //	    file.
+//	 12 A previous run is still in progress, with -skiprunning.
 //	127 The command could not be found.
 //
 // Except in the case of the minimum period having not yet elapsed, it
@@ -60,96 +228,17 @@
 //	255 The command exited unsuccessfully, but the underlying
 //	    operating system does not support examining the exit status.
 //
+// If the command is killed by signal N, prunevery will return with exit
+// code 128+N.
+//
 // Otherwise, prunevery will return with the exit code of the command.
 package main
 
 import (
-	"fmt"
-	"log"
-	"os"
-	"time"
-
-	"path/filepath"
-
 	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
 )
 
-var state struct {
-	cmd cmd.State
-
-	// Name of stat file to track last execution.
-	statname string
-
-	// Minimum periodic execution interval to enforce.
-	period time.Duration
-}
-
-func init() {
-	log.SetFlags(0)
-	state.cmd = cmd.Parse("period")
-
-	var err error
-	state.period, err = time.ParseDuration(state.cmd.Me.Args[0])
-	if err != nil {
-		cmd.ArgError(err)
-	}
-	if state.period < 0 {
-		cmd.BadArgs("period must be non-negative")
-	}
-
-	tmp := os.TempDir()
-	key := cmd.MakeKey(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	state.statname = filepath.Join(tmp, fmt.Sprintf("%s_%s", state.cmd.Me.Name, key))
-}
-
-// chillopen opens the file without erroring if it already exists.
-func chillopen() *os.File {
-	flag := os.O_RDONLY | os.O_CREATE
-	file, err := os.OpenFile(state.statname, flag, 0666)
-	if err != nil {
-		log.Print(err)
-		os.Exit(11)
-	}
-	return file
-}
-
-func shouldrun() bool {
-	flag := os.O_RDONLY | os.O_CREATE | os.O_EXCL
-	file, err := os.OpenFile(state.statname, flag, 0666)
-	if err == nil {
-		file.Close()
-		return true
-	}
-	if !os.IsExist(err) {
-		log.Print(err)
-		os.Exit(11)
-	}
-	// os.IsExist(err) == true
-	file = chillopen()
-	defer file.Close()
-	fi, err2 := file.Stat()
-	if err2 != nil {
-		log.Print(err2)
-		os.Exit(11)
-	}
-	now := time.Now()
-	if now.After(fi.ModTime().Add(state.period)) {
-		if err := os.Chtimes(state.statname, now, now); err != nil {
-			log.Print(err)
-			os.Exit(11)
-		}
-		return true
-	}
-	return false
-}
-
 func main() {
-	if state.period > 0 && !shouldrun() {
-		os.Exit(10)
-	}
-	proc := cmd.NewProc(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	proc.Cmd.Stdout = os.Stdout
-	proc.Cmd.Stderr = os.Stderr
-	proc.StartExit()
-	proc.WaitExit()
+	cmd.Run(prunevery.Mode)
 }
@@ -2,35 +2,201 @@
 
 // prunex runs a command exclusively.
 //
-//	usage: prunex command [argument ...]
+//	usage: prunex [-grace duration] [-wait] [-waitfor duration]
+//	       [-slots n | -shared] [-path path [-passfd]]
+//	       [-backend backend [-lease duration]] [-statedir dir]
+//	       [key option ...] command [argument ...]
 //
 // It is similar to lockf on FreeBSD or flock on Linux.  If the lock
 // cannot be acquired (due to another instance holding it), then prunex
-// exits immediately.
+// exits immediately, describing the holder of the lock, unless it's
+// told to wait.
 //
 // Lock Files
 //
 // prunex runs the given command exclusively by means of lock files.
 // These are supported on Unix-like systems only (the underlying
-// implementation uses golang.org/x/sys/unix.Flock).  The lock files are
-// stored in the default temporary directory (via os.TempDir).
+// implementation uses syscall.Flock).  The lock files are
+// stored in the state directory, as described in the documentation of
+// chrispennello.com/go/prun/cmd.
 //
-// A command-specific lock file is generated by producing a a
+// A command-specific lock file is generated by producing a
 // deterministic and reasonably human-readable string that identifies
 // the command being run.  All non-word characters are consolidated
 // between the command and its arguments and are replaced with
 // underscores.
 //
-// If the length of this string exceeds a reasonable limit, then it will
-// be truncated and the suffix will be a hash of the full string so as
-// to stay within the length limit, but still uniquely and
-// deterministically identify the given command and its arguments.
+// To this string is appended a version number and a hash of the exact
+// command and arguments, so that it uniquely and deterministically
+// identifies them, even if they differ only in punctuation or in how
+// the arguments are split.  If need be, the human-readable part is
+// truncated to stay within a reasonable length limit.
+//
+// The key options -key, -keyargs, and -keycmd instead name the file
+// explicitly or by only some of the arguments, as described in the
+// documentation of chrispennello.com/go/prun/cmd.
 //
 // This command-specific lock file is created, used, and removed in a
 // race-free manner by means of a "global" lock file, stored in the same
 // directory, with name "prunex_global".  This file is not removed
 // automatically.
 //
+// Lock Holders
+//
+// While prunex holds the lock, the command-specific lock file records
+// its process ID, its host name, when it acquired the lock, and the
+// command line being run.  If the lock cannot be acquired, prunex
+// prints this information, for instance
+//
+//	locked by pid 4242 on web1 since 2026-10-18 06:00:01 UTC: sh sync.sh
+//
+// prunstate displays the same information for all held locks.
+//
+// The lock is released as soon as its holder exits, however it exits,
+// unless it was passed to the command with -passfd, as below, and the
+// command, or a process that inherited it in turn, outlives the holder.
+// The lock is then held on behalf of a holder that no longer exists.
+// prunex detects such stale locks, when their holder was on the same
+// host, and says as much, but never breaks them, since whatever still
+// holds the lock would then run alongside the next holder.
+//
+// Slots
+//
+// With -slots, up to the given number of instances of prunex may hold
+// the lock at once, as with a counting semaphore.  For example, to run
+// no more than three backups at once,
+//
+//	prunex -key backup -slots 3 ./backup.sh
+//
+// Each slot has its own command-specific lock file.  The first is named
+// by the key alone, like the lock file of an exclusive lock, with just
+// one slot, and the others by the key followed by ".slot" and the slot
+// number, from 2.  prunex takes the first free slot, and if all of them
+// are taken, it exits, describing all of their holders, or waits, as
+// below, for any of them.  All instances sharing a key should be given
+// the same number of slots.  Otherwise, an instance given fewer slots
+// than another still excludes, and is excluded by, it in the slots they
+// have in common, so an exclusive lock takes up the first slot of a
+// counting lock on the same key.
+//
+// Shared Locks
+//
+// With -shared, prunex shares the lock with any other instances that
+// also share it, and is excluded only by an instance holding it
+// exclusively, that is, without -shared.  Likewise, an exclusive holder
+// excludes, and is excluded by, all shared holders.  The shared and
+// exclusive modes correspond to LOCK_SH and LOCK_EX of flock(2).  For
+// example, to let jobs reading a dataset run concurrently, but never
+// while it's being rebuilt,
+//
+//	prunex -key dataset -shared ./report.sh
+//	prunex -key dataset -wait ./rebuild.sh
+//
+// The lock file records all of the shared holders, and it is removed
+// when the last of them releases the lock.  Waiting shared holders are
+// not kept from acquiring the lock by a waiting exclusive holder, so
+// while shared holders keep overlapping, an exclusive holder waits.  A
+// shared lock may not have several slots.
+//
+// Locking a Path
+//
+// With -path, prunex locks the given file or directory itself, rather
+// than a command-specific lock file, so that different commands
+// touching the same resource exclude each other, whatever their keys.
+// If nothing exists at the path, an empty file is created there.  The
+// path is never removed.  For example, to serialize everything that
+// mutates a repository,
+//
+//	prunex -path /srv/repo git -C /srv/repo pull
+//	prunex -path /srv/repo -shared git -C /srv/repo log
+//
+// Since the path is locked with flock(2), prunex also excludes, and is
+// excluded by, flock(1) and anyone else locking it the same way.  The
+// holders are still recorded in a command-specific lock file, whose key
+// is "path_" and the absolute path made readable, followed by a version
+// number and a hash of it.  Holders that aren't prunex, such as
+// flock(1), aren't recorded, so they're described as unknown.  A lock
+// on a path may not have several slots.
+//
+// With -passfd, the command inherits the locked file or directory as an
+// open file descriptor, whose number is given by the PRUNEX_FD
+// environment variable.  The lock is then held as long as either prunex
+// or the command, or any process that inherits it in turn, keeps it
+// open, even after prunex exits, as with flock(1) given a file
+// descriptor.  The command may release it early by closing it.
+//
+// Backends
+//
+// By default, prunex locks by means of lock files locked with
+// flock(2), as described above.  Those lock only among processes on the
+// same host, but -backend selects another way of locking, so that, for
+// instance, the same cron job on several hosts runs on only one of them
+// at a time.
+//
+//	flock          Lock files in the state directory, the default.
+//	lease:dir      Lease files in the directory dir, typically on a
+//	               file system shared between the hosts, such as NFS.
+//	tcp:address    A lock server listening at the TCP address, as
+//	               served by prunlockd.
+//
+// Slots and waiting work with every backend, but shared locks and locks
+// on a path work with the flock backend only.  The key
+// names the lock regardless of backend, so every host should derive the
+// same key, for instance by giving it explicitly.
+//
+// The lease backend records the holder in a lease file named by the
+// prefix "prunex_lease_" and the key, which it creates atomically by
+// linking it into place.  While the command runs, prunex renews the
+// lease by updating the file's modification time three times per -lease
+// time.Duration (by default, 30s).  A lease that hasn't been renewed
+// for that long, for instance because its holder's host went away, has
+// expired, and the next instance of prunex takes it over.  Expiry
+// compares the file's modification time, as set by the host that last
+// renewed it, with the local clock, so the hosts' clocks must agree to
+// well within the length of the lease, or a lease may be taken over
+// while its holder still runs.  prunex checks that it still holds the
+// lease every second.  For example,
+//
+//	prunex -backend lease:/mnt/shared/locks -key nightly ./nightly.sh
+//
+// The tcp backend asks prunlockd for the lock, which holds it on behalf
+// of prunex as long as prunex stays connected.  For example, with
+// prunlockd listening on lockhost,
+//
+//	prunex -backend tcp:lockhost:7070 -key nightly ./nightly.sh
+//
+// If prunex finds that its lease was taken over, or went unrenewed for
+// longer than -lease, or that its connection to prunlockd was lost, it
+// no longer holds the lock.  So it sends the command SIGTERM, kills it
+// if it has not exited within the -grace time.Duration, and exits with
+// exit code 21.
+//
+// Waiting
+//
+// With -wait, prunex waits for the lock indefinitely rather than
+// exiting immediately.  With -waitfor, it waits at most the given
+// time.Duration, after which it exits with exit code 20.  For example,
+// to serialize deploy steps, but give up after ten minutes,
+//
+//	prunex -key deploy -waitfor 10m ./deploy.sh
+//
+// The lock is tried again every 100ms, so waiting processes acquire it
+// in no particular order.  SIGHUP, SIGINT, and SIGTERM interrupt the
+// wait, and prunex exits with exit code 20 without running the command.
+//
+// While it waits, prunex records its process ID, host name, when it
+// started waiting, and the command line in a file named by the prefix
+// "prunex_wait_", its process ID, and the command-specific key, in the
+// state directory, so that prunstate can display the queue of waiting
+// processes.
+//
+// Signals
+//
+// SIGHUP, SIGINT, and SIGTERM received by prunex are relayed to the
+// command.  If the command has not exited within the -grace
+// time.Duration (by default, 5s) after the first relayed signal, it is
+// killed.
+//
 // Diagnostics
 //
 // prunex may return with the following exit codes.
@@ -38,7 +204,9 @@
 //	  1 An unidentified error occurred when trying to run or wait on
 //	    the command.
 //	  2 Invalid arguments.
-//	 20 Could not acquire lock.
+//	 20 Could not acquire lock, whether immediately, within the
+//	    -waitfor duration, or before being interrupted.
+//	 21 Lost the lock while running the command, and stopped it.
 //	127 The command could not be found.
 //
 // And it will print an appropriate message to standard error.
@@ -48,48 +216,17 @@
 //	255 The command exited unsuccessfully, but the underlying
 //	    operating system does not support examining the exit status.
 //
+// If the command is killed by signal N, prunex will return with exit
+// code 128+N.
+//
 // Otherwise, prunex will return with the exit code of the command.
 package main
 
 import (
-	"fmt"
-	"log"
-	"os"
-
-	"path/filepath"
-
 	"chrispennello.com/go/prun/cmd"
-	"chrispennello.com/go/util/lockfile"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
 )
 
-var state struct {
-	cmd cmd.State
-
-	// Lock file names.
-	globalname string
-	localname  string
-}
-
-func init() {
-	log.SetFlags(0)
-	state.cmd = cmd.Parse()
-
-	tmp := os.TempDir()
-	key := cmd.MakeKey(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	state.globalname = filepath.Join(tmp, fmt.Sprintf("%s_global", state.cmd.Me.Name))
-	state.localname = filepath.Join(tmp, fmt.Sprintf("%s_local_%s", state.cmd.Me.Name, key))
-}
-
 func main() {
-	lc, err := lockfile.LockRm(state.globalname, state.localname)
-	if err != nil {
-		log.Print(err)
-		os.Exit(20)
-	}
-	defer lc.Unlock()
-	proc := cmd.NewProc(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	proc.Cmd.Stdout = os.Stdout
-	proc.Cmd.Stderr = os.Stderr
-	proc.StartExit()
-	proc.WaitExit()
+	cmd.Run(prunex.Mode)
 }
@@ -2,11 +2,54 @@
 
 // prunfor runs a command for an optionally limited amount of time.
 //
-//	usage: prunfor timelimit command [argument ...]
+//	usage: prunfor [-grace duration] [-group] [-subreaper] [-signal signal]
+//	       [-killafter duration] [-idle duration]
+//	       timelimit command [argument ...]
 //
 // timelimit is a non-negative time.Duration.  If timelimit is zero, no
 // time limit will be applied to command's execution.
 //
+// Idle Timeouts
+//
+// If -idle is a positive time.Duration, the command is also stopped if
+// it produces no standard output or error for that long, counting from
+// when it starts, independent of timelimit.  The command is then
+// stopped just as when it times out.  Its output is passed through
+// pipes rather than written directly to prunfor's standard output and
+// error.
+//
+// Soft Timeouts
+//
+// By default, the command is killed as soon as it times out.  If
+// -killafter is a positive time.Duration, the command is instead first
+// sent the signal given by -signal (by default, SIGTERM), giving it a
+// chance to clean up.  If it still hasn't exited after the -killafter
+// duration, it is killed.  This is in the spirit of timeout -k in GNU
+// coreutils.  The signal may be given by name, such as TERM, SIGTERM, or
+// USR1, or by number, up to the highest on the platform.
+//
+// Process Groups
+//
+// By default, only the command itself is killed when it times out, so
+// any processes it has started, such as those of a shell script, are
+// left running.  With -group, the command is started in its own process
+// group, and the whole group is killed on timeout and is sent any
+// relayed signals.  Note that the command is then no longer in the
+// terminal's foreground process group.
+//
+// Processes can escape the group, for example by daemonizing.  With
+// -subreaper (Linux only), prunfor becomes a child subreaper, so that
+// orphaned descendants of the command are reparented to it.  Once the
+// command times out or goes idle, any such orphans are killed and
+// reaped, too.  If the command exits on its own, they're left running.
+//
+// Signals
+//
+// SIGHUP, SIGINT, and SIGTERM received by prunfor are relayed to the
+// command.  If the command has not exited within the -grace
+// time.Duration (by default, 5s) after the first relayed signal, it is
+// killed.
+//
 // Diagnostics
 //
 // prunfor may return with the following exit codes.
@@ -14,7 +57,12 @@
 //	  1 An unidentified error occurred when trying to run or wait on
 //	    the command.
 //	  2 Invalid arguments.
-//	 40 Timed out.
+//	 40 Timed out, and the command was killed.
+//	 41 Timed out, and the command exited after being sent the
+//	    -signal signal.
+//	 42 Idle, and the command was killed.
+//	 43 Idle, and the command exited after being sent the -signal
+//	    signal.
 //	127 The command could not be found.
 //
 // And it will print an appropriate message to standard error.
@@ -24,71 +72,17 @@
 //	255 The command exited unsuccessfully, but the underlying
 //	    operating system does not support examining the exit status.
 //
+// If the command is killed by signal N, prunfor will return with exit
+// code 128+N.
+//
 // Otherwise, prunfor will return with the exit code of the command.
 package main
 
 import (
-	"log"
-	"os"
-	"time"
-
 	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunfor"
 )
 
-var state struct {
-	cmd cmd.State
-
-	// Time timelimit that the specified program can run for.
-	timelimit time.Duration
-}
-
-func init() {
-	log.SetFlags(0)
-	state.cmd = cmd.Parse("timelimit")
-
-	var err error
-	state.timelimit, err = time.ParseDuration(state.cmd.Me.Args[0])
-	if err != nil {
-		cmd.ArgError(err)
-	}
-	if state.timelimit < 0 {
-		cmd.BadArgs("timelimit must be non-negative")
-	}
-}
-
 func main() {
-	proc := cmd.NewProc(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	proc.Cmd.Stdout = os.Stdout
-	proc.Cmd.Stderr = os.Stderr
-	proc.StartExit()
-
-	done := make(chan struct{})
-	go func() {
-		proc.WaitExit()
-		close(done)
-	}()
-
-	if state.timelimit > 0 {
-		timeout := make(chan struct{})
-		go func() {
-			time.Sleep(state.timelimit)
-			close(timeout)
-		}()
-		select {
-		case <-done:
-			// Process exited successfully before timeout.
-			// Thus, there's no need to wait on that anymore
-			// in combination with the timeout.
-			break
-		case <-timeout:
-			log.Printf("timed out: %s\n", proc)
-			if err := proc.Cmd.Process.Kill(); err != nil {
-				log.Print(err)
-			}
-			proc.Wait() // Don't care if this errors.
-			os.Exit(40)
-		}
-	}
-
-	<-done
+	cmd.Run(prunfor.Mode)
 }
@@ -0,0 +1,75 @@
+// chris 2026-10-18
+
+// prunlockd serves locks to prunex over TCP.
+//
+//	usage: prunlockd [-grace duration] address [command [argument ...]]
+//
+// address is a TCP address, such as "localhost:7070" or ":7070", as for
+// net.Listen.  prunlockd listens there for instances of prunex using
+// the tcp backend, such as
+//
+//	prunex -backend tcp:lockhost:7070 -key nightly ./nightly.sh
+//
+// and serves them locks named by their keys.  Each lock is held on
+// behalf of the instance that acquired it for as long as its connection
+// stays open, so it's released when prunex exits, however it exits.
+// TCP keepalives are enabled, so a lock held on behalf of a host that
+// goes away is eventually released as well.  If prunlockd itself exits,
+// all the locks are released, and their holders say as much, though
+// their commands carry on.
+//
+// The locks are kept only in memory.  Slots work as with the flock
+// backend, but shared locks and locks on a path do not.
+//
+// Without a command, prunlockd serves until it's killed.  Given a
+// command, it serves only while running the command, and then exits,
+// as with the other utilities.  This is handy for hosting the locks for
+// the duration of a job, or for trying out the tcp backend on a single
+// host.
+//
+//	prunlockd localhost:7070 sh -c '
+//	    prunex -backend tcp:localhost:7070 -key a sleep 1 &
+//	    prunex -backend tcp:localhost:7070 -key a true'
+//
+// prunlockd doesn't authenticate its clients, so it should listen only
+// where trusted hosts can reach it.
+//
+// Signals
+//
+// SIGHUP, SIGINT, and SIGTERM received by prunlockd while running a
+// command are relayed to the command.  If the command has not exited
+// within the -grace time.Duration (by default, 5s) after the first
+// relayed signal, it is killed.
+//
+// Diagnostics
+//
+// prunlockd may return with the following exit codes.
+//
+//	  1 An unidentified error occurred when trying to run or wait on
+//	    the command.
+//	  2 Invalid arguments.
+//	 20 Could not listen on the address, or could no longer accept
+//	    connections there.
+//	127 The command could not be found.
+//
+// And it will print an appropriate message to standard error.
+//
+// In addition, prunlockd may return with the following exit code.
+//
+//	255 The command exited unsuccessfully, but the underlying
+//	    operating system does not support examining the exit status.
+//
+// If the command is killed by signal N, prunlockd will return with exit
+// code 128+N.
+//
+// Otherwise, prunlockd will return with the exit code of the command.
+package main
+
+import (
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunlockd"
+)
+
+func main() {
+	cmd.Run(prunlockd.Mode)
+}
@@ -2,7 +2,7 @@
 
 // prunsleep runs a command after sleeping a random amount of time.
 //
-//	usage: prunsleep bound command [argument ...]
+//	usage: prunsleep [-grace duration] bound command [argument ...]
 //
 // bound is a non-negative time.Duration.  If bound is zero, the command
 // will be executed immediately.  Generally, it represents a limit on
@@ -22,6 +22,13 @@
 // As a point of comparison, sleeping for a random amount of time when
 // run as a cron job is a feature in FreeBSD's portsnap cron command.
 //
+// Signals
+//
+// SIGHUP, SIGINT, and SIGTERM received by prunsleep are relayed to the
+// command.  If the command has not exited within the -grace
+// time.Duration (by default, 5s) after the first relayed signal, it is
+// killed.
+//
 // Diagnostics
 //
 // prunsleep may return with the following exit codes.
@@ -38,48 +45,17 @@
 //	255 The command exited unsuccessfully, but the underlying
 //	    operating system does not support examining the exit status.
 //
+// If the command is killed by signal N, prunsleep will return with exit
+// code 128+N.
+//
 // Otherwise, prunsleep will return with the exit code of the command.
 package main
 
 import (
-	"log"
-	"os"
-	"time"
-
-	"math/rand"
-
 	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunsleep"
 )
 
-var state struct {
-	cmd cmd.State
-
-	// Maximum time that we will sleep before running the specified
-	// program.
-	bound time.Duration
-}
-
-func init() {
-	log.SetFlags(0)
-	state.cmd = cmd.Parse("bound")
-
-	var err error
-	state.bound, err = time.ParseDuration(state.cmd.Me.Args[0])
-	if err != nil {
-		cmd.ArgError(err)
-	}
-	if state.bound < 0 {
-		cmd.BadArgs("bound must be non-negative")
-	}
-}
-
 func main() {
-	if state.bound != 0 {
-		time.Sleep(time.Duration(rand.Int63n(state.bound.Nanoseconds())))
-	}
-	proc := cmd.NewProc(state.cmd.Cmd.Name, state.cmd.Cmd.Args)
-	proc.Cmd.Stdout = os.Stdout
-	proc.Cmd.Stderr = os.Stderr
-	proc.StartExit()
-	proc.WaitExit()
+	cmd.Run(prunsleep.Mode)
 }
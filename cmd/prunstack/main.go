@@ -0,0 +1,102 @@
+// chris 2026-10-18
+
+// prunstack runs a command through several of the other prun utilities
+// in a single process.
+//
+//	usage: prunstack [-ex] [prunex option ...] [-every period]
+//	       [prunevery option ...] [-sleep bound] [-fail maxfail]
+//	       [-for timelimit] [prunfor option ...] [-statedir dir]
+//	       [key option ...] command [argument ...]
+//
+// Each option stacks the behavior of one of the other prun utilities
+// around the command, just as if the corresponding utility had been
+// invoked with the option's value as its argument.  They share the same
+// lock, stat, and log files, too.  So, for example,
+//
+//	prunstack -ex -every 1h -fail 3 -for 10m job
+//
+// behaves like
+//
+//	prunex prunevery 1h prunfail 3 prunfor 10m job
+//
+// but only one process runs alongside the command, rather than four,
+// and the behaviors' exit codes are distinct from one another, as
+// described below.  prunex's options, such as -waitfor, prunevery's
+// options, such as -onsuccess, and prunfor's other options, such as
+// -idle and -killafter, are also accepted.  prunevery's -schedule, with
+// -sameday, and -skiprunning are enforced even without -every.
+//
+// Order
+//
+// Regardless of the order of the options, the behaviors are always
+// stacked in the following order, outermost first.
+//
+//	-ex     Run exclusively.
+//	-every  Enforce a minimum period between executions.
+//	-sleep  Sleep a random amount of time.
+//	-fail   Guard the output.
+//	-for    Limit the running time.
+//
+// So, for instance, the lock is held while checking the minimum period,
+// so that two concurrent invocations can't both decide to run, and time
+// outs count as failures whose output is guarded.
+//
+// Diagnostics
+//
+// Each behavior exits with its own exit codes, which are the same as
+// those of the corresponding utility and are distinct from one another.
+// If a behavior fails on its own account, for instance by not acquiring
+// the lock, prunstack exits with its exit code, and the behaviors
+// stacked inside of it, along with the command itself, are not run.
+// Otherwise, prunstack exits with the exit code of the command.  The
+// behaviors' exit codes overlap with the command's own, though, so a
+// command that itself exits with 10, 11, 12, 20, 21, 31, 32, or 40
+// through 43 can't be told apart from the behavior exiting with that
+// code.
+//
+// prunstack may return with the following exit codes.
+//
+//	  1 An unidentified error occurred when trying to run or wait on
+//	    the command.
+//	  2 Invalid arguments.
+//	 10 -every: Minimum period not yet elapsed.
+//	 11 -every: Error opening, creating, examining, or updating the
+//	    stat file.
+//	 12 -every: A previous run is still in progress, with
+//	    -skiprunning.
+//	 20 -ex: Could not acquire lock.
+//	 21 -ex: Lost the lock while running the command, and stopped
+//	    it.
+//	 31 -fail: Error opening, creating, or writing to log file.
+//	 32 -fail: Error copying consolidated output to standard error.
+//	 40 -for: Timed out, and the command was killed.
+//	 41 -for: Timed out, and the command exited after being sent the
+//	    -signal signal.
+//	 42 -idle: Idle, and the command was killed.
+//	 43 -idle: Idle, and the command exited after being sent the
+//	    -signal signal.
+//	127 The command could not be found.
+//
+// Except in the case of the minimum period having not yet elapsed, it
+// will print an appropriate message to standard error, subject to
+// -fail.
+//
+// In addition, prunstack may return with the following exit code.
+//
+//	255 The command exited unsuccessfully, but the underlying
+//	    operating system does not support examining the exit status.
+//
+// If the command is killed by signal N, prunstack will return with exit
+// code 128+N.
+//
+// Otherwise, prunstack will return with the exit code of the command.
+package main
+
+import (
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunstack"
+)
+
+func main() {
+	cmd.Run(prunstack.Mode)
+}
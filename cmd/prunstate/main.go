@@ -0,0 +1,78 @@
+// chris 2026-10-18
+
+// prunstate inspects the lock, stat, and log files kept by prunex,
+// prunevery, and prunfail.
+//
+//	usage: prunstate [-statedir dir] [key option ...]
+//	       [command [argument ...]]
+//
+// Without a command, prunstate lists all of the files in the state
+// directory, one per line, along with the mode keeping each file, the
+// key naming it, and what it records.
+//
+//	$ prunstate
+//	MODE       KEY                            STATE
+//	prunex     sync_sh.v2.5b0c0e31b4f4c2a6    locked by pid 4242 on web1 since 2026-10-18 06:00:01 UTC: sh sync.sh
+//	prunevery  sync_sh.v2.5b0c0e31b4f4c2a6    last run 2026-10-18 06:00:01 UTC, period 8h0m0s, next eligible 2026-10-18 14:00:01 UTC
+//	prunfail   backup.v2.0d8f0a1c9e3b7d44     2 consecutive failures, last run 2026-10-18 05:30:02 UTC
+//
+// Given a command, prunstate instead displays the files for that
+// command line, if they exist, with the same key that the other
+// utilities would use, as chosen by the same options.  Given -key alone,
+// it displays the files named by that key.
+//
+//	$ prunstate sh sync.sh
+//
+// The state directory and the key options are described in the
+// documentation of chrispennello.com/go/prun/cmd.
+//
+// prunstate doesn't run the command, nor does it modify any of the
+// files.
+//
+// Lock Holders
+//
+// A prunex lock file exists only while its lock is held, or, if it's
+// stale, while it's held on behalf of a holder that no longer exists.
+// prunstate displays the holder's process ID, host name, when it
+// acquired the lock, and the command line it's running, and whether the
+// lock is stale, as described in the documentation of prunex.  It
+// likewise displays any processes waiting for the lock.
+//
+// Lease files kept by the lease backend of prunex are displayed along
+// with when they expire, if they're kept in the state directory, or if
+// -statedir gives the directory they're kept in.  Locks held through
+// the tcp backend are kept only by prunlockd, so prunstate can't display
+// them.
+//
+// Periods
+//
+// prunevery records the period it enforced in the stat file, from which
+// prunstate computes when the command is next eligible to run, taking
+// any backoff after failures into account, and measuring the period by
+// the same clock as prunevery.  It also records the outcome of the last
+// run, how long it took and its exit status, and any consecutive
+// failures and skips, which prunstate displays, noting whether the stat
+// file was stamped in the future.  Stat files written by older versions
+// of prunevery don't record these, so only the time of the last run is
+// displayed.  It likewise displays whether a run is in progress, as
+// recorded in the run file.
+//
+// Diagnostics
+//
+// prunstate may return with the following exit codes.
+//
+//	1 The state directory could not be created or read.
+//	2 Invalid arguments.
+//
+// And it will print an appropriate message to standard error.  Errors
+// reading individual files are displayed in place of their state.
+package main
+
+import (
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunstate"
+)
+
+func main() {
+	cmd.Run(prunstate.Mode)
+}
@@ -0,0 +1,99 @@
+// chris 2026-10-18 Child subreaping (Linux only).
+
+package cmd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"io/ioutil"
+	"path/filepath"
+)
+
+// prctl option, from linux/prctl.h.
+const prSetChildSubreaper = 36
+
+// Subreaper marks the calling process as a child subreaper, so that
+// orphaned descendants of its commands are reparented to it rather
+// than to init.  Call Reap to get rid of them.
+func Subreaper() error {
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// children returns the process IDs of the calling process's children.
+func children() ([]int, error) {
+	self := os.Getpid()
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, stat := range stats {
+		data, err := ioutil.ReadFile(stat)
+		if err != nil {
+			// The process has probably since exited.
+			continue
+		}
+		// The second field, the command name, is parenthesized
+		// and may contain spaces.  The parent process ID is the
+		// second field after it.
+		s := string(data)
+		fields := strings.Fields(s[strings.LastIndex(s, ")")+1:])
+		if len(fields) < 2 {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil || ppid != self {
+			continue
+		}
+		pid, err := strconv.Atoi(filepath.Base(filepath.Dir(stat)))
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// Reap kills and waits on the orphaned descendants reparented to the
+// calling process as a subreaper, until there are none left.  Orphans
+// are told apart from the commands of Procs, which are left alone,
+// because no Proc started them, so only call Reap if the calling
+// process starts commands through Procs alone.
+func Reap() error {
+	for {
+		procs.Lock()
+		pids, err := children()
+		var orphans []int
+		for _, pid := range pids {
+			if !procs.pids[pid] {
+				syscall.Kill(pid, syscall.SIGKILL) // Don't care if this errors.
+				orphans = append(orphans, pid)
+			}
+		}
+		procs.Unlock()
+		if err != nil {
+			return err
+		}
+		if len(orphans) == 0 {
+			return nil
+		}
+		for _, pid := range orphans {
+			var ws syscall.WaitStatus
+			for {
+				// ECHILD means someone else has waited
+				// on it already.
+				_, err := syscall.Wait4(pid, &ws, 0, nil)
+				if err != syscall.EINTR {
+					break
+				}
+			}
+		}
+	}
+}
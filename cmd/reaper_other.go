@@ -0,0 +1,20 @@
+// chris 2026-10-18
+
+//go:build !linux
+
+package cmd
+
+import "errors"
+
+var errNoSubreaper = errors.New("subreaper not supported")
+
+// Subreaper returns an error: child subreapers are supported on Linux
+// only.
+func Subreaper() error {
+	return errNoSubreaper
+}
+
+// Reap does nothing: child subreapers are supported on Linux only.
+func Reap() error {
+	return nil
+}
@@ -0,0 +1,107 @@
+// chris 2026-10-18 Results of waiting on commands.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Result describes how a command terminated and the resources it used.
+// It is returned by Wait.
+type Result struct {
+	// Exit status of the command.  If the command was killed by a
+	// signal, it is 128 plus the signal number.  If the operating
+	// system does not support determining the exit status, it is 0
+	// if the command exited successfully and UnknownCode otherwise.
+	Code int
+	// Signal that killed the command, if any, and whether it dumped
+	// core as a result.
+	Signal   syscall.Signal
+	CoreDump bool
+
+	// When the command was started and when it was waited on.
+	Start time.Time
+	End   time.Time
+
+	// User and system CPU time used by the command.
+	User   time.Duration
+	System time.Duration
+
+	// Maximum resident set size of the command in bytes, and the
+	// number of blocks it read and wrote.  These are zero if the
+	// operating system does not report them.
+	MaxRSS   int64
+	InBlock  int64
+	OutBlock int64
+}
+
+// UnknownCode is the Code of a command that exited unsuccessfully when
+// the operating system does not support determining its exit status.
+// It is the only Code that isn't an exit status, and the prun utilities
+// exit with 255 in its place.
+const UnknownCode = -1
+
+// newResult returns the Result for the given process state.
+func newResult(ps *os.ProcessState, start, end time.Time) *Result {
+	r := &Result{
+		Start:  start,
+		End:    end,
+		User:   ps.UserTime(),
+		System: ps.SystemTime(),
+	}
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	switch {
+	case ok && ws.Signaled():
+		r.Signal = ws.Signal()
+		r.CoreDump = ws.CoreDump()
+		r.Code = 128 + int(r.Signal)
+	case ok:
+		r.Code = ws.ExitStatus()
+	case !ps.Success():
+		r.Code = UnknownCode
+	}
+	r.usage(ps)
+	return r
+}
+
+// Success reports whether the command exited successfully.
+func (r *Result) Success() bool {
+	return r.Code == 0
+}
+
+// Elapsed returns the wall time the command ran for.
+func (r *Result) Elapsed() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+// String returns a one-line summary of the Result, such as
+//
+//	exit 3 real 1.2s user 0.8s sys 0.1s maxrss 5.1MiB in 0 out 16
+func (r *Result) String() string {
+	var b strings.Builder
+	if r.Signal != 0 {
+		fmt.Fprintf(&b, "signal %d", int(r.Signal))
+		if r.CoreDump {
+			b.WriteString(" (core dumped)")
+		}
+	} else if r.Code == UnknownCode {
+		b.WriteString("exit unknown")
+	} else {
+		fmt.Fprintf(&b, "exit %d", r.Code)
+	}
+	fmt.Fprintf(&b, " real %s user %s sys %s",
+		r.Elapsed().Round(time.Millisecond),
+		r.User.Round(time.Millisecond),
+		r.System.Round(time.Millisecond))
+	if r.MaxRSS != 0 {
+		fmt.Fprintf(&b, " maxrss %.1fMiB", float64(r.MaxRSS)/(1<<20))
+	}
+	if r.InBlock != 0 || r.OutBlock != 0 {
+		fmt.Fprintf(&b, " in %d out %d", r.InBlock, r.OutBlock)
+	}
+	return b.String()
+}
@@ -0,0 +1,12 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package cmd
+
+import (
+	"os"
+)
+
+// usage does nothing: only the CPU times are reported.
+func (r *Result) usage(ps *os.ProcessState) {}
@@ -0,0 +1,28 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// usage fills in the resource usage reported by the operating system.
+func (r *Result) usage(ps *os.ProcessState) {
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return
+	}
+	// ru_maxrss is in kilobytes, except on Darwin, where it is in
+	// bytes.
+	unit := int64(1024)
+	if runtime.GOOS == "darwin" || runtime.GOOS == "ios" {
+		unit = 1
+	}
+	r.MaxRSS = int64(ru.Maxrss) * unit
+	r.InBlock = int64(ru.Inblock)
+	r.OutBlock = int64(ru.Oublock)
+}
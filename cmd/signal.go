@@ -0,0 +1,127 @@
+// chris 2026-10-18 Signal relaying.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"os/signal"
+)
+
+// DefaultGrace is the default amount of time a Proc gives its command
+// to exit after relaying a signal to it, after which it is killed.
+const DefaultGrace = 5 * time.Second
+
+// RelaySignals are the signals that a Proc relays to its command by
+// default.
+var RelaySignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM}
+
+// signalNames maps signal names, without the "SIG" prefix, to signals.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"ABRT": syscall.SIGABRT,
+	"KILL": syscall.SIGKILL,
+	"ALRM": syscall.SIGALRM,
+	"TERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal given by name, such as "TERM" or
+// "SIGTERM", or by number, such as "15", up to the highest signal
+// number on the platform.
+func ParseSignal(s string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > maxSignal() {
+			return 0, errors.New("invalid signal: " + s)
+		}
+		return syscall.Signal(n), nil
+	}
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	sig, ok := signalNames[name]
+	if !ok {
+		sig, ok = userSignals[name]
+	}
+	if !ok {
+		return 0, errors.New("unknown signal: " + s)
+	}
+	return sig, nil
+}
+
+var errNotStarted = errors.New("not started")
+
+// watch starts relaying the Proc's Relay signals received by the
+// parent process to the command until the command is waited on, and
+// sends it SIGTERM if the Proc's context is done first.  After the
+// first relayed signal or SIGTERM, if the command has not exited
+// within the grace period, it is killed.
+func (p *Proc) watch() {
+	var sigc chan os.Signal
+	if len(p.Relay) > 0 {
+		sigc = make(chan os.Signal, 1)
+		signal.Notify(sigc, p.Relay...)
+	}
+	done := p.ctx.Done()
+	if sigc == nil && done == nil {
+		return
+	}
+	go func() {
+		if sigc != nil {
+			defer signal.Stop(sigc)
+		}
+		var grace <-chan time.Time
+		stop := func(sig os.Signal) {
+			p.Signal(sig) // Don't care if this errors.
+			if grace == nil && p.Grace > 0 {
+				grace = time.After(p.Grace)
+			}
+		}
+		for {
+			select {
+			case sig := <-sigc:
+				stop(sig)
+			case <-done:
+				done = nil
+				p.ctxMu.Lock()
+				p.ctxErr = p.ctx.Err()
+				p.ctxMu.Unlock()
+				stop(syscall.SIGTERM)
+			case <-grace:
+				p.Kill() // Don't care if this errors.
+			case <-p.exited:
+				return
+			}
+		}
+	}()
+}
+
+// contextErr returns the error of the Proc's context if the command
+// was stopped because the context was done.
+func (p *Proc) contextErr() error {
+	p.ctxMu.Lock()
+	defer p.ctxMu.Unlock()
+	return p.ctxErr
+}
+
+// Signal sends the signal to the command, or to its whole process
+// group if Group is set.
+func (p *Proc) Signal(sig os.Signal) error {
+	if p.Cmd.Process == nil {
+		return errNotStarted
+	}
+	if p.Group {
+		return signalGroup(p.Cmd.Process.Pid, sig)
+	}
+	return p.Cmd.Process.Signal(sig)
+}
+
+// Kill forcibly kills the command, or its whole process group if Group
+// is set.
+func (p *Proc) Kill() error {
+	return p.Signal(os.Kill)
+}
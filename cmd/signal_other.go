@@ -0,0 +1,17 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package cmd
+
+import (
+	"syscall"
+)
+
+// userSignals is empty: there are no user-defined signals.
+var userSignals map[string]syscall.Signal
+
+// maxSignal returns the highest signal number, that of SIGTERM.
+func maxSignal() int {
+	return int(syscall.SIGTERM)
+}
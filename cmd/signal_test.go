@@ -0,0 +1,45 @@
+// chris 2026-10-18
+
+package cmd
+
+import (
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func testParseSignalExpect(t *testing.T, s string, expect syscall.Signal) {
+	sig, err := ParseSignal(s)
+	if err != nil {
+		t.Errorf("ParseSignal(%q) errored: %v\n", s, err)
+		return
+	}
+	if sig != expect {
+		t.Errorf("ParseSignal(%q) != %v (got %v)\n", s, expect, sig)
+	}
+}
+
+func testParseSignalBad(t *testing.T, s string) {
+	if sig, err := ParseSignal(s); err == nil {
+		t.Errorf("ParseSignal(%q) should have errored (got %v)\n", s, sig)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	testParseSignalExpect(t, "TERM", syscall.SIGTERM)
+	testParseSignalExpect(t, "SIGTERM", syscall.SIGTERM)
+	testParseSignalExpect(t, "term", syscall.SIGTERM)
+	testParseSignalExpect(t, "sigkill", syscall.SIGKILL)
+	testParseSignalExpect(t, "9", syscall.Signal(9))
+	testParseSignalExpect(t, strconv.Itoa(maxSignal()), syscall.Signal(maxSignal()))
+	if sig, ok := userSignals["USR1"]; ok {
+		testParseSignalExpect(t, "USR1", sig)
+		testParseSignalExpect(t, "sigusr2", userSignals["USR2"])
+	}
+
+	testParseSignalBad(t, "")
+	testParseSignalBad(t, "0")
+	testParseSignalBad(t, "-1")
+	testParseSignalBad(t, strconv.Itoa(maxSignal()+1))
+	testParseSignalBad(t, "SIGBOGUS")
+}
@@ -0,0 +1,27 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package cmd
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// userSignals maps the names of the user-defined signals, without the
+// "SIG" prefix, to signals.
+var userSignals = map[string]syscall.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// maxSignal returns the highest signal number: 64 on Linux, whose
+// real-time signals go up to SIGRTMAX, and 31 on other Unix-like
+// systems.
+func maxSignal() int {
+	if runtime.GOOS == "linux" {
+		return 64
+	}
+	return 31
+}
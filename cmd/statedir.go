@@ -0,0 +1,213 @@
+// chris 2026-10-18 State directory resolution.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"path/filepath"
+)
+
+// StateDirEnv is the environment variable naming the state directory
+// when the -statedir option isn't given.
+const StateDirEnv = "PRUN_STATE_DIR"
+
+// SystemStateDir is the state directory of the superuser when neither
+// the -statedir option, StateDirEnv, nor XDG_STATE_HOME are given.
+const SystemStateDir = "/var/lib/prun"
+
+// statedir is the state directory, settable with the -statedir option.
+var statedir string
+
+// StateDirVar defines the -statedir option in Flags.  Modes that keep
+// lock, stat, or log files call it to define their options.
+func StateDirVar() {
+	PathVar(&statedir, "statedir", "", "`dir`ectory for lock, stat, and log files (default $"+StateDirEnv+")")
+}
+
+// baseStateDir returns the state directory before any per-user
+// subdirectory is taken into account.  In order of preference, it is
+// given by the -statedir option, StateDirEnv, or XDG_STATE_HOME, and
+// otherwise is SystemStateDir for the superuser, the XDG default under
+// the home directory for other users, or, failing all else, a
+// directory named by the user ID in the default temporary directory.
+func baseStateDir() string {
+	if statedir != "" {
+		return statedir
+	}
+	if dir := os.Getenv(StateDirEnv); dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			return abs
+		}
+		return dir
+	}
+	// Relative paths are invalid and to be ignored, says the XDG
+	// Base Directory Specification.
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "prun")
+	}
+	if os.Getuid() == 0 {
+		return SystemStateDir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", "prun")
+	}
+	return filepath.Join(os.TempDir(), "prun-"+strconv.Itoa(os.Getuid()))
+}
+
+// StateDir returns the directory in which to keep lock, stat, and log
+// files, creating it if necessary.
+//
+// The directory is created with permissions allowing access by its
+// owner only.  If it already exists but isn't private to the current
+// user, as with a directory shared by several users, a subdirectory
+// named by the user ID is used instead, so that users can't interfere
+// with each other's files.  So that others can't replace that
+// subdirectory with their own, the shared directory must be owned by
+// the superuser or the current user, or have its sticky bit set.  If
+// it doesn't, or the subdirectory isn't private either, StateDir
+// returns an error.
+func StateDir() (string, error) {
+	return stateDir(true)
+}
+
+// LookupStateDir returns the state directory, as StateDir does, but
+// without creating it, so it may not exist.
+func LookupStateDir() (string, error) {
+	return stateDir(false)
+}
+
+// stateDir returns the state directory, creating it if create is set.
+// Otherwise, if it doesn't exist, it's returned all the same.
+func stateDir(create bool) (string, error) {
+	dir := baseStateDir()
+	if create {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	fi, err := os.Stat(dir)
+	if os.IsNotExist(err) && !create {
+		return dir, nil
+	} else if err != nil {
+		return "", err
+	}
+	if private(fi) {
+		return dir, nil
+	}
+	if !shareable(fi) {
+		return "", fmt.Errorf("%s: shared state directory neither owned by root nor sticky", dir)
+	}
+	dir = filepath.Join(dir, strconv.Itoa(os.Getuid()))
+	if create {
+		if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	}
+	fi, err = os.Lstat(dir)
+	if os.IsNotExist(err) && !create {
+		return dir, nil
+	} else if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() || !private(fi) {
+		return "", fmt.Errorf("%s: state directory not private to user", dir)
+	}
+	return dir, nil
+}
+
+// StatePath returns the path of the named file in the state directory,
+// creating the directory if necessary.
+func StatePath(name string) (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// KeyPath returns the path of the file in the state directory named by
+// prefix, the key, and suffix, creating the directory if necessary.
+func KeyPath(prefix, key, suffix string) (string, error) {
+	return StatePath(prefix + key + suffix)
+}
+
+// KeyPath returns the path of the file in the state directory named by
+// prefix, the key, and suffix, as the package-level KeyPath does.
+//
+// If the key is the one MakeKey derives from the command and all of its
+// arguments, and there's no such file yet, but there is one named by
+// the corresponding LegacyKey, either in the state directory or in the
+// default temporary directory where it used to be kept, that file is
+// first moved into place, so that its state carries over.
+func (p *Proc) KeyPath(prefix, key, suffix string) (string, error) {
+	path, err := KeyPath(prefix, key, suffix)
+	if err != nil {
+		return "", err
+	}
+	if key == MakeKey(p.command, p.args) {
+		migrate(path, prefix+LegacyKey(p.command, p.args)+suffix)
+	}
+	return path, nil
+}
+
+// migrate moves the file with the given name to path, unless path
+// already exists, from the first of the state directory and the default
+// temporary directory with a regular file of that name owned by the
+// current user.  A file that's copied is checked again once opened, in
+// case another user swapped it in after it was examined.  Errors are
+// ignored: at worst, the state starts afresh.
+func migrate(path, name string) {
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		return
+	}
+	for _, dir := range []string{filepath.Dir(path), os.TempDir()} {
+		old := filepath.Join(dir, name)
+		fi, err := os.Lstat(old)
+		if err != nil || !fi.Mode().IsRegular() || !private(fi) {
+			continue
+		}
+		// Across file systems, renaming fails, so copy instead.
+		if os.Rename(old, path) == nil || copyFile(path, old, fi) == nil {
+			os.Remove(old)
+			return
+		}
+	}
+}
+
+// copyFile copies the file old, with the given FileInfo, to the new
+// file path, preserving its modification time.  It fails unless the
+// file opened is the one described by fi and is still private to the
+// current user.
+func copyFile(path, old string, fi os.FileInfo) error {
+	src, err := os.Open(old)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	sfi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(fi, sfi) || !sfi.Mode().IsRegular() || !private(sfi) {
+		return fmt.Errorf("%s: legacy file not private to user", old)
+	}
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(path, fi.ModTime(), fi.ModTime())
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
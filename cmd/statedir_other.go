@@ -0,0 +1,21 @@
+// chris 2026-10-18
+
+//go:build !unix
+
+package cmd
+
+import (
+	"os"
+)
+
+// private reports that the file is private: file ownership is only
+// examined on Unix-like systems.
+func private(fi os.FileInfo) bool {
+	return true
+}
+
+// shareable reports that the directory may be shared: file ownership is
+// only examined on Unix-like systems.
+func shareable(fi os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,34 @@
+// chris 2026-10-18
+
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// private reports whether the file is owned by the current user and
+// not writable by anyone else.
+func private(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return int(st.Uid) == os.Getuid() && fi.Mode().Perm()&0022 == 0
+}
+
+// shareable reports whether the directory is owned by the superuser or
+// the current user, or has its sticky bit set, so that no other user
+// may rename or remove the entries within it that aren't theirs.
+func shareable(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeSticky != 0 {
+		return true
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return st.Uid == 0 || int(st.Uid) == os.Getuid()
+}
@@ -1,4 +1,11 @@
 // chris 090315
 
 // Package prun implements a set of handy process running utilities.
+//
+// The utilities themselves are under chrispennello.com/go/prun/cmd.
+// This package exposes their behaviors to Go programs: each of the Run
+// functions runs a command just as the corresponding utility would, but
+// takes a context.Context and returns a Result rather than exiting the
+// process.  Any lock, stat, and log files are kept in the same state
+// directory as the utilities', resolved by cmd.StateDir.
 package prun
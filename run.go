@@ -0,0 +1,200 @@
+// chris 2026-10-18
+
+package prun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"chrispennello.com/go/prun/cmd"
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfail"
+	"chrispennello.com/go/prun/cmd/mode/prunfor"
+	"chrispennello.com/go/prun/cmd/mode/prunparallel"
+	"chrispennello.com/go/prun/cmd/mode/prunsleep"
+)
+
+// Spec specifies a command to run, much like an exec.Cmd.
+type Spec struct {
+	// The name of the command and any of its arguments.
+	Name string
+	Args []string
+
+	// The working directory and environment of the command, as
+	// with exec.Cmd.
+	Dir string
+	Env []string
+
+	// The command's standard input, output, and error, as with
+	// exec.Cmd.  Any that are nil are connected to the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// The key naming any lock, stat, or log files, which must be
+	// valid according to cmd.CheckKey.  If empty, cmd.MakeKey
+	// derives it from Name and Args, just as the prun utilities do.
+	// To instead derive it from only some of the arguments, as with
+	// their -keyargs option, set it to the cmd.MakeKey of
+	// cmd.SelectArgs.
+	Key string
+}
+
+// key returns the key naming any lock, stat, or log files.
+func (s *Spec) key() (string, error) {
+	if s.Key != "" {
+		return s.Key, cmd.CheckKey(s.Key)
+	}
+	return cmd.MakeKey(s.Name, s.Args), nil
+}
+
+// proc returns a new cmd.Proc for the command with the given arguments,
+// stopped if the context is done.  Unlike the prun utilities, it
+// doesn't relay any signals.
+func (s *Spec) proc(ctx context.Context, args []string) *cmd.Proc {
+	p := cmd.NewProcContext(ctx, s.Name, args)
+	p.Relay = nil
+	p.Cmd.Dir = s.Dir
+	p.Cmd.Env = s.Env
+	p.Cmd.Stdin = s.Stdin
+	p.Cmd.Stdout = s.Stdout
+	p.Cmd.Stderr = s.Stderr
+	return p
+}
+
+// Result is the result of running a command.
+type Result struct {
+	// The exit code that the corresponding prun utility would exit
+	// with: 0 if the command succeeded, one of the utility's own
+	// exit codes if it failed on its own account, and the command's
+	// exit code otherwise.
+	Code int
+	// A message describing what went wrong, if anything.
+	Msg string
+	// How the command exited and the resources it used, if it was
+	// run.  It is always nil for RunParallel.
+	Proc *cmd.Result
+}
+
+// Success reports whether the command was run and succeeded.
+func (r Result) Success() bool {
+	return r.Code == 0
+}
+
+// result converts a *cmd.ProcError into a Result.
+func result(perr *cmd.ProcError) Result {
+	if perr == nil {
+		return Result{}
+	}
+	return Result{Code: perr.Code, Msg: perr.Msg}
+}
+
+// Run runs the command through the given layers, such as those of the
+// modes under chrispennello.com/go/prun/cmd/mode, the first outermost.
+// If the context is done while the command runs, it is sent SIGTERM
+// and, if it has not exited within cmd.DefaultGrace, killed.
+func Run(ctx context.Context, spec Spec, layers ...cmd.Layer) Result {
+	p := spec.proc(ctx, spec.Args)
+	r := result(cmd.Stack(layers...)(p))
+	r.Proc = p.Result()
+	return r
+}
+
+// badArgs returns the result of invalid arguments, with exit code 2,
+// just as for the prun utilities.
+func badArgs(err error) Result {
+	return Result{Code: 2, Msg: err.Error()}
+}
+
+// runKeyed runs the command through the layer that layer returns for
+// the spec's key.  If the key is invalid, the result is that of
+// badArgs.
+func runKeyed(ctx context.Context, spec Spec, layer func(key string) cmd.Layer) Result {
+	key, err := spec.key()
+	if err != nil {
+		return badArgs(err)
+	}
+	return Run(ctx, spec, layer(key))
+}
+
+// RunExclusive runs the command exclusively, with the given options, as
+// prunex does.  If the options are invalid, the command isn't run, and
+// the result has exit code 2.
+func RunExclusive(ctx context.Context, spec Spec, opts prunex.Options) Result {
+	if err := opts.Check(); err != nil {
+		return badArgs(err)
+	}
+	return runKeyed(ctx, spec, func(key string) cmd.Layer {
+		return prunex.Layer(key, opts)
+	})
+}
+
+// RunEvery runs the command only if at least period has elapsed since
+// it was last run, with the given options, as prunevery does.  If the
+// options are invalid, the command isn't run, and the result has exit
+// code 2.
+func RunEvery(ctx context.Context, spec Spec, period time.Duration, opts prunevery.Options) Result {
+	if err := opts.Check(); err != nil {
+		return badArgs(err)
+	}
+	return runKeyed(ctx, spec, func(key string) cmd.Layer {
+		return prunevery.Layer(key, period, opts)
+	})
+}
+
+// RunAfterSleep runs the command after sleeping a random amount of time
+// less than bound, as prunsleep does.  If bound is negative, the command
+// isn't run, and the result has exit code 2.
+func RunAfterSleep(ctx context.Context, spec Spec, bound time.Duration) Result {
+	if bound < 0 {
+		return badArgs(errors.New("bound must be non-negative"))
+	}
+	return Run(ctx, spec, prunsleep.Layer(bound))
+}
+
+// RunWithTimeout runs the command, killing it if it runs for longer
+// than timelimit, as prunfor does.
+func RunWithTimeout(ctx context.Context, spec Spec, timelimit time.Duration) Result {
+	return RunWithLimits(ctx, spec, prunfor.Limits{Timelimit: timelimit})
+}
+
+// RunWithLimits runs the command within the given limits, as prunfor
+// does.  If the limits are invalid, the command isn't run, and the
+// result has exit code 2.  Subreaper isn't supported, because it would
+// kill the calling program's own children, so it's invalid, too.
+func RunWithLimits(ctx context.Context, spec Spec, limits prunfor.Limits) Result {
+	if err := limits.Check(); err != nil {
+		return badArgs(err)
+	}
+	if limits.Subreaper {
+		return badArgs(errors.New("subreaper is only supported by prunfor"))
+	}
+	return Run(ctx, spec, prunfor.Layer(limits))
+}
+
+// RunGuarded runs the command, guarding its output, as prunfail does.
+// The command's combined output is only written to spec.Stderr if it
+// succeeds or has failed more than maxfail consecutive times.
+func RunGuarded(ctx context.Context, spec Spec, maxfail int) Result {
+	return runKeyed(ctx, spec, func(key string) cmd.Layer {
+		return prunfail.Layer(key, maxfail)
+	})
+}
+
+// RunParallel runs the command total times, at most concur at a time,
+// as prunparallel does.  If indextemplate is not empty, it is replaced
+// in the command's arguments by each command's 0-based index.  The
+// result is that of the first command to fail, if any.  If concur is
+// zero, no command is run, and the result has exit code 2.
+func RunParallel(ctx context.Context, spec Spec, total, concur uint64, indextemplate string) Result {
+	if concur < 1 {
+		return badArgs(errors.New("concur must be positive"))
+	}
+	newProc := func(index uint64) *cmd.Proc {
+		return spec.proc(ctx, prunparallel.InjectIndex(spec.Args, indextemplate, index))
+	}
+	return result(prunparallel.Parallel(total, concur, newProc, cmd.Exec))
+}
@@ -0,0 +1,105 @@
+// chris 2026-10-18
+
+package prun
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"chrispennello.com/go/prun/cmd/mode/prunevery"
+	"chrispennello.com/go/prun/cmd/mode/prunex"
+	"chrispennello.com/go/prun/cmd/mode/prunfor"
+	"chrispennello.com/go/prun/cmd/mode/prunsleep"
+)
+
+func testRunExpect(t *testing.T, what string, r Result, code int) {
+	t.Logf("%s result %+v\n", what, r)
+	if r.Code != code {
+		t.Errorf("%s exited %d, expected %d (message %q)\n", what, r.Code, code, r.Msg)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	r := Run(ctx, Spec{Name: "true"})
+	testRunExpect(t, "true", r, 0)
+	if r.Proc == nil || r.Proc.Code != 0 {
+		t.Errorf("true result %+v\n", r.Proc)
+	}
+	testRunExpect(t, "exit 3", Run(ctx, Spec{Name: "sh", Args: []string{"-c", "exit 3"}}), 3)
+	testRunExpect(t, "nonexistent", Run(ctx, Spec{Name: "prun-nonexistent"}), 127)
+
+	var out bytes.Buffer
+	testRunExpect(t, "echo", Run(ctx, Spec{Name: "echo", Args: []string{"hi"}, Stdout: &out}), 0)
+	if out.String() != "hi\n" {
+		t.Errorf("echo output %q, expected %q\n", out.String(), "hi\n")
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := Run(ctx, Spec{Name: "true"})
+	if r.Success() {
+		t.Errorf("ran despite canceled context\n")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r = Run(ctx, Spec{Name: "sleep", Args: []string{"10"}})
+	if r.Success() {
+		t.Errorf("sleep succeeded despite context timing out\n")
+	}
+}
+
+func TestRunWithTimeout(t *testing.T) {
+	ctx := context.Background()
+	testRunExpect(t, "sleep", RunWithTimeout(ctx, Spec{Name: "sleep", Args: []string{"10"}}, 100*time.Millisecond), 40)
+	testRunExpect(t, "true", RunWithTimeout(ctx, Spec{Name: "true"}, time.Minute), 0)
+
+	// Without a signal given, the command is sent SIGTERM.
+	spec := Spec{Name: "sh", Args: []string{"-c", `trap 'kill $!; exit 0' TERM; sleep 10 & wait`}}
+	limits := prunfor.Limits{Timelimit: 100 * time.Millisecond, Killafter: 5 * time.Second}
+	start := time.Now()
+	testRunExpect(t, "soft timeout", RunWithLimits(ctx, spec, limits), 41)
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("soft timeout took %s\n", d)
+	}
+}
+
+func TestRunParallel(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	spec := Spec{Name: "echo", Args: []string{"index", "{}"}, Stdout: &out}
+	testRunExpect(t, "echo", RunParallel(ctx, spec, 3, 1, "{}"), 0)
+	if out.String() != "index 0\nindex 1\nindex 2\n" {
+		t.Errorf("unexpected output %q\n", out.String())
+	}
+
+	spec = Spec{Name: "sh", Args: []string{"-c", "exit {}"}}
+	testRunExpect(t, "exit", RunParallel(ctx, spec, 3, 1, "{}"), 1)
+
+	// With no concurrency, nothing could ever run.
+	testRunExpect(t, "no concurrency", RunParallel(ctx, spec, 3, 0, "{}"), 2)
+	testRunExpect(t, "nothing at no concurrency", RunParallel(ctx, spec, 0, 0, "{}"), 2)
+}
+
+func TestRunAfterSleep(t *testing.T) {
+	ctx := context.Background()
+	spec := Spec{Name: "true"}
+	testRunExpect(t, "no sleep", RunAfterSleep(ctx, spec, 0), 0)
+	testRunExpect(t, "short sleep", RunAfterSleep(ctx, spec, time.Millisecond), 0)
+	testRunExpect(t, "negative bound", RunAfterSleep(ctx, spec, -time.Second), 2)
+	testRunExpect(t, "negative bound layer", Run(ctx, spec, prunsleep.Layer(-time.Second)), 0)
+}
+
+func TestRunBadOptions(t *testing.T) {
+	ctx := context.Background()
+	spec := Spec{Name: "true"}
+	testRunExpect(t, "bad exclusive options", RunExclusive(ctx, spec, prunex.Options{PassFD: true}), 2)
+	testRunExpect(t, "bad schedule", RunEvery(ctx, spec, 0, prunevery.Options{Schedule: "0 25 * * *"}), 2)
+	testRunExpect(t, "bad limits", RunWithLimits(ctx, spec, prunfor.Limits{Idle: -time.Second}), 2)
+	testRunExpect(t, "subreaper", RunWithLimits(ctx, spec, prunfor.Limits{Subreaper: true}), 2)
+}